@@ -0,0 +1,90 @@
+/**
+ * Spectrum-Bootstrap - A bootstrap for Minecraft launchers
+ * Copyright (C) 2023-2024 - Oxodao
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ **/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+const (
+	COMPRESSION_NONE = ""
+	COMPRESSION_GZIP = "gzip"
+	COMPRESSION_ZSTD = "zstd"
+	// Only used by the Java runtime manifests
+	COMPRESSION_LZMA = "lzma"
+)
+
+// Sent when fetching manifests, the server is free to ignore it
+const ACCEPT_ENCODING = COMPRESSION_ZSTD + ", " + COMPRESSION_GZIP
+
+// Returns a reader decompressing r on the fly
+// The format is either a Content-Encoding or the format of a compressed file in a manifest
+func NewDecompressor(r io.Reader, format string) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(format)) {
+	case COMPRESSION_NONE, "identity":
+		return io.NopCloser(r), nil
+	case COMPRESSION_GZIP, "x-gzip":
+		return gzip.NewReader(r)
+	case COMPRESSION_ZSTD:
+		// The workers already decompress many files at once
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+
+		return decoder.IOReadCloser(), nil
+	case COMPRESSION_LZMA:
+		decoder, err := lzma.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+
+		return io.NopCloser(decoder), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression: %v", format)
+	}
+}
+
+// Returns a reader applying the patch on the fly
+// Only patches made with "zstd --patch-from=previous" are supported for now
+func NewPatchReader(r io.Reader, format string, previousPath string) (io.ReadCloser, error) {
+	if strings.ToLower(format) != COMPRESSION_ZSTD {
+		return nil, fmt.Errorf("unsupported patch format: %v", format)
+	}
+
+	previous, err := os.ReadFile(previousPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// The previous file is used as the dictionary the patch refers to
+	decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderDictRaw(0, previous))
+	if err != nil {
+		return nil, err
+	}
+
+	return decoder.IOReadCloser(), nil
+}
@@ -0,0 +1,666 @@
+/**
+ * Spectrum-Bootstrap - A bootstrap for Minecraft launchers
+ * Copyright (C) 2023-2024 - Oxodao
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ **/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	DEFAULT_MAX_DOWNLOADS          = 8
+	DEFAULT_MAX_DOWNLOADS_PER_HOST = 4
+
+	MAX_CORRUPTED_RETRIES = 2
+
+	PART_SUFFIX           = ".part"
+	PART_VALIDATOR_SUFFIX = ".part.validator"
+)
+
+var ErrNoUrl = errors.New("no url to download the file from")
+
+type Downloader struct {
+	bSettings *BootstrapSettings
+	index     *FileIndex
+	// Optional, the downloaded files are added to it
+	store *ObjectStore
+
+	workers int
+	perHost int
+
+	hostSlotsMtx sync.Mutex
+	hostSlots    map[string]chan struct{}
+
+	// Only set when the fastest mirrors should be tried first
+	latencies *MirrorLatencies
+
+	// Not nil while paused, closed when resuming
+	pauseMtx sync.Mutex
+	resumed  chan struct{}
+
+	// Optional, keeps track of the bytes downloaded across all the files
+	Progress *ProgressReporter
+
+	// Called from the worker goroutines, they must be safe for concurrent use
+	// The worker index can be used to know which UI slot to update
+	OnFileStart    func(worker int, f Downloadable)
+	OnFileProgress func(worker int, f Downloadable, written int64)
+	OnFileDone     func(worker int, f Downloadable)
+}
+
+func GetDownloader(bs *BootstrapSettings, index *FileIndex, store *ObjectStore) *Downloader {
+	workers := bs.MaxDownloads
+	if workers <= 0 {
+		workers = DEFAULT_MAX_DOWNLOADS
+	}
+
+	perHost := bs.MaxDownloadsPerHost
+	if perHost <= 0 {
+		perHost = DEFAULT_MAX_DOWNLOADS_PER_HOST
+	}
+
+	d := &Downloader{
+		bSettings: bs,
+		index:     index,
+		store:     store,
+		workers:   workers,
+		perHost:   perHost,
+		hostSlots: map[string]chan struct{}{},
+	}
+
+	if bs.PreferFastestMirror {
+		d.latencies = NewMirrorLatencies(bs)
+	}
+
+	return d
+}
+
+// Amount of workers that will actually be started for the files
+// More of them than the hosts allow would only wait for a slot, i.e. with a single host
+func (d *Downloader) WorkerCount(files []Downloadable) int {
+	hosts := map[string]bool{}
+	for _, f := range files {
+		urls := slices.Clone(f.Urls)
+		if f.Compressed != nil {
+			urls = append(urls, f.Compressed.Urls...)
+		}
+
+		for _, p := range f.Patches {
+			urls = append(urls, p.Urls...)
+		}
+
+		for _, u := range urls {
+			hosts[hostOf(u)] = true
+		}
+	}
+
+	return min(d.workers, len(files), max(len(hosts), 1)*d.perHost)
+}
+
+// Downloads every file, stopping at the first error or when the context is cancelled
+// Partial downloads are kept so that they are resumed on the next run
+func (d *Downloader) Download(ctx context.Context, files []Downloadable) error {
+	jobs := make(chan Downloadable)
+	abort := make(chan struct{})
+
+	var (
+		wg        sync.WaitGroup
+		abortOnce sync.Once
+		firstErr  error
+	)
+
+	for i := 0; i < d.WorkerCount(files); i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+
+			for f := range jobs {
+				if err := d.downloadFile(ctx, worker, f); err != nil {
+					abortOnce.Do(func() {
+						firstErr = err
+						close(abort)
+					})
+
+					return
+				}
+			}
+		}(i)
+	}
+
+feed:
+	for _, f := range files {
+		select {
+		case jobs <- f:
+		case <-abort:
+			break feed
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+
+	wg.Wait()
+
+	if firstErr == nil {
+		return ctx.Err()
+	}
+
+	return firstErr
+}
+
+// The workers stop reading from the network until Resume() is called
+func (d *Downloader) Pause() {
+	d.pauseMtx.Lock()
+	defer d.pauseMtx.Unlock()
+
+	if d.resumed == nil {
+		d.resumed = make(chan struct{})
+	}
+}
+
+func (d *Downloader) Resume() {
+	d.pauseMtx.Lock()
+	defer d.pauseMtx.Unlock()
+
+	if d.resumed != nil {
+		close(d.resumed)
+		d.resumed = nil
+	}
+}
+
+func (d *Downloader) IsPaused() bool {
+	d.pauseMtx.Lock()
+	defer d.pauseMtx.Unlock()
+
+	return d.resumed != nil
+}
+
+func (d *Downloader) waitIfPaused(ctx context.Context) error {
+	d.pauseMtx.Lock()
+	resumed := d.resumed
+	d.pauseMtx.Unlock()
+
+	if resumed == nil {
+		return nil
+	}
+
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func hostOf(rawUrl string) string {
+	if u, err := url.Parse(rawUrl); err == nil {
+		return u.Host
+	}
+
+	return rawUrl
+}
+
+// Blocks until a download slot for the host is available
+// and returns the function releasing it
+func (d *Downloader) acquireHost(ctx context.Context, rawUrl string) (func(), error) {
+	host := hostOf(rawUrl)
+
+	d.hostSlotsMtx.Lock()
+	slots, ok := d.hostSlots[host]
+	if !ok {
+		slots = make(chan struct{}, d.perHost)
+		d.hostSlots[host] = slots
+	}
+	d.hostSlotsMtx.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// The state of a file being downloaded by a worker
+type fileDownload struct {
+	worker int
+	f      Downloadable
+
+	// The compression of what is being downloaded, empty for the raw file
+	format string
+	// Set when downloading a patch, the file it applies to
+	patchBase string
+
+	// Bytes of this file that are currently counted in the progress
+	credited  int64
+	sizeAdded bool
+}
+
+func (d *Downloader) downloadFile(ctx context.Context, worker int, f Downloadable) error {
+	dl := &fileDownload{worker: worker, f: f}
+
+	if err := d.waitIfPaused(ctx); err != nil {
+		return err
+	}
+
+	if len(f.Urls) == 0 && f.Compressed == nil && len(f.Patches) == 0 {
+		return fmt.Errorf("%w: %v", ErrNoUrl, f.Path)
+	}
+
+	err := os.MkdirAll(filepath.Dir(f.Path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	// Corrupted downloads are not retried by WithRetries as the request itself went well
+	// but the server or something in-between might have sent us garbage
+	var source string
+	for corruptions := 0; ; corruptions++ {
+		source, err = d.downloadAnyVariant(ctx, dl)
+		if err == nil {
+			break
+		}
+
+		if !errors.Is(err, ErrHashMismatch) || corruptions >= MAX_CORRUPTED_RETRIES {
+			return err
+		}
+
+		fmt.Printf("Downloaded file is corrupted, retrying: %v\n", err)
+	}
+
+	if f.Executable {
+		if err := os.Chmod(f.Path, os.ModePerm); err != nil {
+			return err
+		}
+	}
+
+	// The file was verified while being downloaded
+	if err := d.index.Record(f.Path, f, source); err != nil {
+		return err
+	}
+
+	// The install works without the store, it's only a missed opportunity to share the file
+	if err := d.store.Add(f.Path, f); err != nil {
+		fmt.Printf("Failed to add %v to the object store: %v\n", f.Path, err)
+	}
+
+	if d.OnFileDone != nil {
+		d.OnFileDone(worker, f)
+	}
+
+	return nil
+}
+
+// A patch is tried first, then the compressed file
+// the raw one is only downloaded if everything else failed
+func (d *Downloader) downloadAnyVariant(ctx context.Context, dl *fileDownload) (string, error) {
+	if patch := findPatch(dl.f); patch != nil {
+		dl.format, dl.patchBase = patch.Format, dl.f.Previous
+		source, err := d.downloadFromMirrors(ctx, dl, patch.Urls)
+		dl.format, dl.patchBase = COMPRESSION_NONE, ""
+
+		if err == nil || ctx.Err() != nil || (len(dl.f.Urls) == 0 && dl.f.Compressed == nil) {
+			return source, err
+		}
+
+		fmt.Printf("Failed to patch %v, downloading the whole file: %v\n", dl.f.Path, err)
+	}
+
+	if c := dl.f.Compressed; c != nil {
+		dl.format = c.Format
+		source, err := d.downloadFromMirrors(ctx, dl, c.Urls)
+		dl.format = COMPRESSION_NONE
+
+		if err == nil || ctx.Err() != nil || len(dl.f.Urls) == 0 {
+			return source, err
+		}
+
+		fmt.Printf("Failed to download the compressed %v, trying the raw file: %v\n", dl.f.Path, err)
+	}
+
+	return d.downloadFromMirrors(ctx, dl, dl.f.Urls)
+}
+
+// Tries every url in order until one of them works
+// and returns the one that served the file
+func (d *Downloader) downloadFromMirrors(ctx context.Context, dl *fileDownload, urls []string) (string, error) {
+	var err error
+
+	if d.latencies != nil {
+		urls = d.latencies.Sort(ctx, urls)
+	}
+
+	for _, u := range urls {
+		err = d.downloadFrom(ctx, dl, u)
+		if err == nil {
+			return u, nil
+		}
+
+		if ctx.Err() != nil {
+			return "", err
+		}
+
+		fmt.Printf("Failed to download %v from %v: %v\n", dl.f.Path, u, err)
+	}
+
+	return "", err
+}
+
+func (d *Downloader) downloadFrom(ctx context.Context, dl *fileDownload, u string) error {
+	release, err := d.acquireHost(ctx, u)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return WithRetries(ctx, func(attempt int) error {
+		if d.OnFileStart != nil {
+			d.OnFileStart(dl.worker, dl.f)
+		}
+
+		return d.fetchFile(ctx, dl, u)
+	})
+}
+
+// A single download attempt, resuming the partial file when possible
+// The target is only replaced once the downloaded file matches its hash
+func (d *Downloader) fetchFile(ctx context.Context, dl *fileDownload, u string) error {
+	f := dl.f
+	partPath := f.Path + PART_SUFFIX
+
+	offset, validator := resumeInfo(f.Path)
+	// The partial file holds the decompressed data, there is no way to know
+	// where to resume the compressed one
+	if len(dl.format) > 0 && offset > 0 {
+		RemovePartialDownload(f.Path)
+		offset, validator = 0, ""
+	}
+	if offset > 0 && f.Size > 0 && offset >= int64(f.Size) {
+		// Fully downloaded in a previous run but never promoted
+		if err := VerifyFile(partPath, f); err != nil {
+			RemovePartialDownload(f.Path)
+
+			return d.fetchFile(ctx, dl, u)
+		}
+
+		d.setOnDisk(dl, offset)
+
+		return promotePartialDownload(f.Path)
+	}
+
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%v-", offset))
+		if len(validator) > 0 {
+			header.Set("If-Range", validator)
+		}
+	}
+
+	resp, err := DoGet(ctx, d.bSettings, u, header)
+	if err != nil {
+		var statusErr *HttpStatusError
+		if offset > 0 && errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			// Our partial file is no good, starting over
+			RemovePartialDownload(f.Path)
+
+			return d.fetchFile(ctx, dl, u)
+		}
+
+		return err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	if resp.StatusCode == http.StatusPartialContent {
+		if contentRangeStart(resp) != offset {
+			resp.Body.Close()
+			RemovePartialDownload(f.Path)
+
+			return d.fetchFile(ctx, dl, u)
+		}
+
+		flags |= os.O_APPEND
+	} else {
+		// The server sent the whole file, either because it does not support ranges
+		// or because the file changed since the previous attempt
+		offset = 0
+		flags |= os.O_TRUNC
+
+		if len(dl.format) == 0 {
+			if err := saveResumeInfo(f.Path, resp); err != nil {
+				return err
+			}
+		}
+	}
+
+	d.setOnDisk(dl, offset)
+	// The progress counts decompressed bytes, the Content-Length is of no use for compressed files
+	if f.Size == 0 && len(dl.format) == 0 && resp.ContentLength > 0 && !dl.sizeAdded && d.Progress != nil {
+		d.Progress.AddTotal(offset + resp.ContentLength)
+		dl.sizeAdded = true
+	}
+
+	verifier := NewFileVerifier(f)
+	if offset > 0 {
+		// The beginning of the file was downloaded previously
+		// it needs to be hashed too
+		if err := hashPartialDownload(partPath, verifier); err != nil {
+			return err
+		}
+	}
+
+	out, err := os.OpenFile(partPath, flags, 0666)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	var decompressed io.ReadCloser
+	if len(dl.patchBase) > 0 {
+		decompressed, err = NewPatchReader(&pausableReader{ctx: ctx, d: d, r: resp.Body}, dl.format, dl.patchBase)
+	} else {
+		decompressed, err = NewDecompressor(&pausableReader{ctx: ctx, d: d, r: resp.Body}, dl.format)
+	}
+	if err != nil {
+		return err
+	}
+	defer decompressed.Close()
+
+	body := &countingReader{
+		r: decompressed,
+		onRead: func(n int64) {
+			d.addDownloaded(dl, n)
+		},
+	}
+
+	if _, err := io.Copy(io.MultiWriter(out, verifier), body); err != nil {
+		return err
+	}
+
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	if err := verifier.Verify(); err != nil {
+		RemovePartialDownload(f.Path)
+		d.setOnDisk(dl, 0)
+
+		return err
+	}
+
+	return promotePartialDownload(f.Path)
+}
+
+// Called when we know how much of the file is on disk, i.e. when resuming it
+// or when the partial file was thrown away
+func (d *Downloader) setOnDisk(dl *fileDownload, n int64) {
+	if d.Progress != nil {
+		d.Progress.Add(n - dl.credited)
+	}
+	dl.credited = n
+
+	if d.OnFileProgress != nil {
+		d.OnFileProgress(dl.worker, dl.f, n)
+	}
+}
+
+func (d *Downloader) addDownloaded(dl *fileDownload, n int64) {
+	if d.Progress != nil {
+		d.Progress.Add(n)
+	}
+	dl.credited += n
+
+	if d.OnFileProgress != nil {
+		d.OnFileProgress(dl.worker, dl.f, dl.credited)
+	}
+}
+
+// The patch applying to the previous version of the file, if there is one
+func findPatch(f Downloadable) *PatchDownloadable {
+	if len(f.Patches) == 0 || len(f.Previous) == 0 {
+		return nil
+	}
+
+	previousHash := GetHash(f.Previous)
+	if len(previousHash) == 0 {
+		return nil
+	}
+
+	for i, patch := range f.Patches {
+		if strings.EqualFold(patch.From, previousHash) {
+			return &f.Patches[i]
+		}
+	}
+
+	return nil
+}
+
+type pausableReader struct {
+	ctx context.Context
+	d   *Downloader
+	r   io.Reader
+}
+
+func (pr *pausableReader) Read(p []byte) (int, error) {
+	if err := pr.d.waitIfPaused(pr.ctx); err != nil {
+		return 0, err
+	}
+
+	return pr.r.Read(p)
+}
+
+func hashPartialDownload(partPath string, verifier *FileVerifier) error {
+	part, err := os.Open(partPath)
+	if err != nil {
+		return err
+	}
+	defer part.Close()
+
+	_, err = io.Copy(verifier, part)
+
+	return err
+}
+
+// Size of the partial download and the If-Range validator to use
+// The offset is 0 when the download can't be resumed
+func resumeInfo(path string) (int64, string) {
+	validator, err := os.ReadFile(path + PART_VALIDATOR_SUFFIX)
+	if err != nil {
+		return 0, ""
+	}
+
+	fi, err := os.Stat(path + PART_SUFFIX)
+	if err != nil {
+		return 0, ""
+	}
+
+	return fi.Size(), string(validator)
+}
+
+// The validator file is only kept when the server advertised byte ranges
+// so that we don't try to resume a download from a server that can't do it
+func saveResumeInfo(path string, resp *http.Response) error {
+	validatorPath := path + PART_VALIDATOR_SUFFIX
+
+	if resp.Header.Get("Accept-Ranges") != "bytes" {
+		if err := os.Remove(validatorPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		return nil
+	}
+
+	// Weak ETags can't be used with If-Range
+	validator := resp.Header.Get("ETag")
+	if len(validator) == 0 || strings.HasPrefix(validator, "W/") {
+		validator = resp.Header.Get("Last-Modified")
+	}
+
+	return os.WriteFile(validatorPath, []byte(validator), 0666)
+}
+
+// "Content-Range: bytes 200-1000/1001" => 200
+func contentRangeStart(resp *http.Response) int64 {
+	var start, end int64
+	_, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d", &start, &end)
+	if err != nil {
+		return -1
+	}
+
+	return start
+}
+
+func promotePartialDownload(path string) error {
+	if err := os.Rename(path+PART_SUFFIX, path); err != nil {
+		return err
+	}
+
+	if err := os.Remove(path + PART_VALIDATOR_SUFFIX); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func RemovePartialDownload(path string) {
+	os.Remove(path + PART_SUFFIX)
+	os.Remove(path + PART_VALIDATOR_SUFFIX)
+}
+
+// Returns the file a partial download belongs to
+func PartialDownloadTarget(path string) (string, bool) {
+	if strings.HasSuffix(path, PART_VALIDATOR_SUFFIX) {
+		return strings.TrimSuffix(path, PART_VALIDATOR_SUFFIX), true
+	}
+
+	if strings.HasSuffix(path, PART_SUFFIX) {
+		return strings.TrimSuffix(path, PART_SUFFIX), true
+	}
+
+	return "", false
+}
@@ -0,0 +1,201 @@
+/**
+ * Spectrum-Bootstrap - A bootstrap for Minecraft launchers
+ * Copyright (C) 2023-2024 - Oxodao
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ **/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// What we knew about a file the last time its hash was verified
+// If nothing changed since, there is no need to hash it again
+type FileIndexEntry struct {
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+	Inode   uint64 `json:"inode"`
+	Sha1    string `json:"sha1,omitempty"`
+	Sha256  string `json:"sha256,omitempty"`
+
+	// The url the file was downloaded from, if it was downloaded by the bootstrap
+	Source string `json:"source,omitempty"`
+}
+
+func (e FileIndexEntry) matches(fi os.FileInfo, f Downloadable) bool {
+	other := indexEntry(fi, f)
+	other.Source = e.Source
+
+	return e == other
+}
+
+type FileIndex struct {
+	mtx sync.Mutex
+
+	path       string
+	fullVerify bool
+
+	files map[string]FileIndexEntry
+	// Only the files checked during this run are saved
+	// so that the removed ones don't stay forever in the index
+	seen map[string]bool
+}
+
+func LoadFileIndex(bs *BootstrapSettings, fullVerify bool) *FileIndex {
+	index := &FileIndex{
+		path:       filepath.Join(bs.LauncherPath, "file_index.json"),
+		fullVerify: fullVerify,
+		files:      map[string]FileIndexEntry{},
+		seen:       map[string]bool{},
+	}
+
+	data, err := os.ReadFile(index.path)
+	if err != nil {
+		return index
+	}
+
+	// A corrupted index only means that everything will be hashed again
+	if err := json.Unmarshal(data, &index.files); err != nil {
+		fmt.Println(err)
+		index.files = map[string]FileIndexEntry{}
+	}
+
+	return index
+}
+
+// Same as VerifyFile, but trusts the index if the file didn't change since it was last verified
+func (i *FileIndex) VerifyFile(path string, f Downloadable) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if !i.fullVerify {
+		i.mtx.Lock()
+		entry, ok := i.files[path]
+		i.mtx.Unlock()
+
+		if ok && entry.matches(fi, f) {
+			i.markSeen(path)
+
+			return nil
+		}
+	}
+
+	if err := VerifyFile(path, f); err != nil {
+		return err
+	}
+
+	i.mtx.Lock()
+	entry := indexEntry(fi, f)
+	entry.Source = i.files[path].Source
+	i.files[path] = entry
+	i.seen[path] = true
+	i.mtx.Unlock()
+
+	return nil
+}
+
+// Adds a file that was just verified, i.e. after its download
+func (i *FileIndex) Record(path string, f Downloadable, source string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	i.mtx.Lock()
+	entry := indexEntry(fi, f)
+	entry.Source = source
+	i.files[path] = entry
+	i.seen[path] = true
+	i.mtx.Unlock()
+
+	return nil
+}
+
+// The url the file was downloaded from, if known
+func (i *FileIndex) Source(path string) string {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+
+	return i.files[path].Source
+}
+
+// Used when a tree is renamed, i.e. when the staging tree is swapped in
+func (i *FileIndex) MovePrefix(from, to string) {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+
+	from += string(filepath.Separator)
+	to += string(filepath.Separator)
+
+	for path, entry := range i.files {
+		if !strings.HasPrefix(path, from) {
+			continue
+		}
+
+		newPath := to + strings.TrimPrefix(path, from)
+		i.files[newPath] = entry
+		delete(i.files, path)
+
+		if i.seen[path] {
+			i.seen[newPath] = true
+			delete(i.seen, path)
+		}
+	}
+}
+
+func (i *FileIndex) Save() error {
+	i.mtx.Lock()
+	files := map[string]FileIndexEntry{}
+	for path := range i.seen {
+		files[path] = i.files[path]
+	}
+	i.mtx.Unlock()
+
+	data, err := json.Marshal(files)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := i.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0666); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, i.path)
+}
+
+func (i *FileIndex) markSeen(path string) {
+	i.mtx.Lock()
+	i.seen[path] = true
+	i.mtx.Unlock()
+}
+
+func indexEntry(fi os.FileInfo, f Downloadable) FileIndexEntry {
+	return FileIndexEntry{
+		Size:    fi.Size(),
+		ModTime: fi.ModTime().UnixNano(),
+		Inode:   fileInode(fi),
+		Sha1:    f.Sha1,
+		Sha256:  f.Sha256,
+	}
+}
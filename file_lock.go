@@ -0,0 +1,88 @@
+/**
+ * Spectrum-Bootstrap - A bootstrap for Minecraft launchers
+ * Copyright (C) 2023-2024 - Oxodao
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ **/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const LOCK_POLL_INTERVAL = 200 * time.Millisecond
+
+var errLocked = errors.New("already locked")
+
+// An exclusive lock shared with the other processes, i.e. the bootstraps of other brands
+// It is released by the OS if the process dies
+type FileLock struct {
+	f *os.File
+}
+
+// Returns false if someone else holds the lock
+func TryLock(path string) (*FileLock, bool, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, false, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0666)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := lockFile(f); err != nil {
+		f.Close()
+
+		if errors.Is(err, errLocked) {
+			return nil, false, nil
+		}
+
+		return nil, false, err
+	}
+
+	return &FileLock{f: f}, true, nil
+}
+
+// Waits until the lock is available or the context is cancelled
+func AcquireLock(ctx context.Context, path string) (*FileLock, error) {
+	for {
+		lock, ok, err := TryLock(path)
+		if err != nil || ok {
+			return lock, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(LOCK_POLL_INTERVAL):
+		}
+	}
+}
+
+// The lock files are never removed, another process might be waiting on them
+func (l *FileLock) Release() error {
+	if l == nil {
+		return nil
+	}
+
+	unlockFile(l.f)
+
+	return l.f.Close()
+}
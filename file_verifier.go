@@ -0,0 +1,126 @@
+/**
+ * Spectrum-Bootstrap - A bootstrap for Minecraft launchers
+ * Copyright (C) 2023-2024 - Oxodao
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ **/
+
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+var ErrHashMismatch = errors.New("file does not match the expected hash")
+
+type CorruptedFileError struct {
+	Path     string
+	Kind     string
+	Expected string
+	Actual   string
+}
+
+func (e *CorruptedFileError) Error() string {
+	return fmt.Sprintf("%v is corrupted: expected %v %v, got %v", e.Path, e.Kind, e.Expected, e.Actual)
+}
+
+func (e *CorruptedFileError) Unwrap() error {
+	return ErrHashMismatch
+}
+
+// An io.Writer computing the digests the manifest knows about
+// so that the file can be verified while it is being written
+type FileVerifier struct {
+	f Downloadable
+
+	written int64
+	sha1    hash.Hash
+	sha256  hash.Hash
+}
+
+func NewFileVerifier(f Downloadable) *FileVerifier {
+	v := &FileVerifier{f: f}
+
+	if len(f.Sha1) > 0 {
+		v.sha1 = sha1.New()
+	}
+
+	if len(f.Sha256) > 0 {
+		v.sha256 = sha256.New()
+	}
+
+	return v
+}
+
+func (v *FileVerifier) Write(p []byte) (int, error) {
+	if v.sha1 != nil {
+		v.sha1.Write(p)
+	}
+
+	if v.sha256 != nil {
+		v.sha256.Write(p)
+	}
+
+	v.written += int64(len(p))
+
+	return len(p), nil
+}
+
+// Checks what has been written against the size and digests of the manifest
+func (v *FileVerifier) Verify() error {
+	if v.f.Size > 0 && v.written != int64(v.f.Size) {
+		return &CorruptedFileError{
+			Path:     v.f.Path,
+			Kind:     "size",
+			Expected: fmt.Sprintf("%v", v.f.Size),
+			Actual:   fmt.Sprintf("%v", v.written),
+		}
+	}
+
+	if v.sha256 != nil {
+		if actual := fmt.Sprintf("%x", v.sha256.Sum(nil)); actual != v.f.Sha256 {
+			return &CorruptedFileError{Path: v.f.Path, Kind: "sha256", Expected: v.f.Sha256, Actual: actual}
+		}
+	}
+
+	if v.sha1 != nil {
+		if actual := fmt.Sprintf("%x", v.sha1.Sum(nil)); actual != v.f.Sha1 {
+			return &CorruptedFileError{Path: v.f.Path, Kind: "sha1", Expected: v.f.Sha1, Actual: actual}
+		}
+	}
+
+	return nil
+}
+
+// Checks a file on disk against the size and digests of the manifest
+func VerifyFile(path string, f Downloadable) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	v := NewFileVerifier(f)
+	if _, err := io.Copy(v, file); err != nil {
+		return err
+	}
+
+	return v.Verify()
+}
@@ -44,3 +44,8 @@ func GetLauncherDirectory(s *BootstrapSettings) (string, error) {
 
 	return lp, nil
 }
+
+// Where the data shared between the launchers of every brand is stored
+func GetSharedDirectory() (string, error) {
+	return configdir.LocalConfig(SHARED_FOLDER_NAME), nil
+}
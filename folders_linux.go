@@ -48,3 +48,13 @@ func GetLauncherDirectory(s *BootstrapSettings) (string, error) {
 
 	return lp, nil
 }
+
+// Where the data shared between the launchers of every brand is stored
+func GetSharedDirectory() (string, error) {
+	homedir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(homedir, ".local", "share", SHARED_FOLDER_NAME), nil
+}
@@ -1,6 +1,6 @@
 module github.com/spectrum-mc/bootstrap
 
-go 1.20
+go 1.22
 
 require (
 	fyne.io/fyne v1.4.3
@@ -9,7 +9,10 @@ require (
 	github.com/Masterminds/semver/v3 v3.2.1
 	github.com/jeandeaual/go-locale v0.0.0-20220711133428-7de61946b173
 	github.com/kirsle/configdir v0.0.0-20170128060238-e45d2f54772f
+	github.com/klauspost/compress v1.18.0
 	github.com/nicksnyder/go-i18n/v2 v2.2.2
+	github.com/ulikunitz/xz v0.5.12
+	golang.org/x/sys v0.14.0
 	golang.org/x/text v0.14.0
 )
 
@@ -49,7 +52,6 @@ require (
 	golang.org/x/mobile v0.0.0-20231006135142-2b44d11868fe // indirect
 	golang.org/x/net v0.17.0 // indirect
 	golang.org/x/sync v0.4.0 // indirect
-	golang.org/x/sys v0.14.0 // indirect
 	golang.org/x/term v0.13.0 // indirect
 	golang.org/x/tools v0.14.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
@@ -0,0 +1,270 @@
+/**
+ * Spectrum-Bootstrap - A bootstrap for Minecraft launchers
+ * Copyright (C) 2023-2024 - Oxodao
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ **/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	MAX_RETRIES      = 3
+	RETRY_BASE_DELAY = 500 * time.Millisecond
+	RETRY_MAX_DELAY  = 30 * time.Second
+
+	// We don't want a misconfigured server to freeze the bootstrap
+	MAX_RETRY_AFTER = time.Minute
+
+	// The body of a big file can take as long as it needs to be downloaded
+	// as long as some data keeps coming
+	BODY_IDLE_TIMEOUT = 30 * time.Second
+)
+
+var ErrStalledDownload = errors.New("no data received")
+
+// Only the headers have a timeout, the body is watched by a stallWatchdog
+var httpClient = newHttpClient()
+
+func newHttpClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = 30 * time.Second
+
+	return &http.Client{Transport: transport}
+}
+
+type HttpStatusError struct {
+	Url        string
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *HttpStatusError) Error() string {
+	return fmt.Sprintf("unexpected HTTP status %v %v for %v", e.StatusCode, http.StatusText(e.StatusCode), e.Url)
+}
+
+// Whether retrying the request has a chance to succeed
+func IsRetryable(err error) bool {
+	// Resumed with a Range request on the next attempt
+	if errors.Is(err, ErrStalledDownload) {
+		return true
+	}
+
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var statusErr *HttpStatusError
+	if errors.As(err, &statusErr) {
+		switch statusErr.StatusCode {
+		case http.StatusRequestTimeout,
+			http.StatusTooEarly,
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout:
+			return true
+		default:
+			return false
+		}
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return !dnsErr.IsNotFound
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// Runs fn until it succeeds, fails with a fatal error or runs out of retries
+// Waiting between attempts with an exponential backoff and some jitter
+func WithRetries(ctx context.Context, fn func(attempt int) error) error {
+	var err error
+
+	for attempt := 0; attempt <= MAX_RETRIES; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(retryDelay(attempt, err)):
+			}
+		}
+
+		err = fn(attempt)
+		if err == nil || !IsRetryable(err) {
+			return err
+		}
+
+		fmt.Printf("Attempt %v failed: %v\n", attempt+1, err)
+	}
+
+	return err
+}
+
+func retryDelay(attempt int, err error) time.Duration {
+	var statusErr *HttpStatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		if statusErr.RetryAfter > MAX_RETRY_AFTER {
+			return MAX_RETRY_AFTER
+		}
+
+		return statusErr.RetryAfter
+	}
+
+	delay := RETRY_BASE_DELAY << (attempt - 1)
+	if delay > RETRY_MAX_DELAY {
+		delay = RETRY_MAX_DELAY
+	}
+
+	// Between half and the full delay, so that the workers don't all retry at the same time
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// Does a single GET request on the source matching the url
+// Plain filesystem paths are accepted too
+func DoGet(ctx context.Context, bs *BootstrapSettings, url string, header http.Header) (*http.Response, error) {
+	url = NormalizeUrl(url)
+
+	source, err := GetSource(url)
+	if err != nil {
+		return nil, err
+	}
+
+	// Cancelled by the watchdog when the body stalls
+	reqCtx, cancel := context.WithCancel(ctx)
+
+	resp, err := source.Get(reqCtx, bs, url, header)
+	if err != nil {
+		cancel()
+
+		return nil, err
+	}
+
+	resp.Body = &stallWatchdog{body: resp.Body, timeout: BODY_IDLE_TIMEOUT, cancel: cancel}
+
+	return resp, nil
+}
+
+// Cancels the request when a read of the body gets nothing for too long
+// A connection that stalls would otherwise block the download forever
+type stallWatchdog struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	cancel  context.CancelFunc
+	stalled atomic.Bool
+}
+
+func (w *stallWatchdog) Read(p []byte) (int, error) {
+	timer := time.AfterFunc(w.timeout, func() {
+		w.stalled.Store(true)
+		w.cancel()
+	})
+
+	n, err := w.body.Read(p)
+	timer.Stop()
+
+	if err != nil && err != io.EOF && w.stalled.Load() {
+		return n, fmt.Errorf("%w for %v", ErrStalledDownload, w.timeout)
+	}
+
+	return n, err
+}
+
+func (w *stallWatchdog) Close() error {
+	err := w.body.Close()
+	w.cancel()
+
+	return err
+}
+
+type httpSource struct{}
+
+func (httpSource) Get(ctx context.Context, bs *BootstrapSettings, url string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	SetUserAgent(bs, req)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+
+		statusErr := &HttpStatusError{
+			Url:        url,
+			StatusCode: resp.StatusCode,
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+			statusErr.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+		}
+
+		return nil, statusErr
+	}
+
+	return resp, nil
+}
+
+// Retry-After is either an amount of seconds or an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if len(value) == 0 {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+
+	return 0
+}
@@ -0,0 +1,459 @@
+/**
+ * Spectrum-Bootstrap - A bootstrap for Minecraft launchers
+ * Copyright (C) 2023-2024 - Oxodao
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ **/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	TREE_DIRECTORY = "directory"
+	TREE_FILE      = "file"
+	TREE_LINK      = "link"
+)
+
+var ErrUnsafeLink = errors.New("link pointing outside of the tree")
+
+// An entry the tree must contain, whatever manifest it comes from
+type TreeEntry struct {
+	Type string
+	// Relative to the root of the tree
+	Path string
+	// Only used for files, its Path is ignored
+	Download Downloadable
+	// Only used for links, relative to the directory of the link
+	Target string
+}
+
+// Called while the files are being verified
+type ValidationProgress func(done, total int)
+
+// Checks the tree against its entries
+// If it's not up-to-date, a transaction is started and the returned files
+// have to be downloaded before committing it
+// The store is optional, the files it has are not downloaded again
+func ValidateTree(ctx context.Context, index *FileIndex, store *ObjectStore, root string, entries []TreeEntry, onProgress ValidationProgress) (*Transaction, []Downloadable, error) {
+	if err := checkLinks(entries); err != nil {
+		return nil, nil, err
+	}
+
+	tx, err := RecoverTransaction(root)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if tx == nil {
+		upToDate, err := isTreeUpToDate(ctx, index, root, entries, onProgress)
+		if err != nil || upToDate {
+			return nil, nil, err
+		}
+
+		tx, err = BeginTransaction(root)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	filesToDownload, err := stageTree(ctx, index, store, tx, entries, onProgress)
+
+	return tx, filesToDownload, err
+}
+
+func isTreeUpToDate(ctx context.Context, index *FileIndex, root string, entries []TreeEntry, onProgress ValidationProgress) (bool, error) {
+	if !exists(root) {
+		return false, nil
+	}
+
+	fileSet := make(map[string]bool, len(entries))
+	files := []TreeEntry{}
+
+	for _, e := range entries {
+		path := filepath.Join(root, e.Path)
+		fileSet[path] = true
+
+		if e.Type == TREE_DIRECTORY {
+			fi, err := os.Stat(path)
+			if err != nil || !fi.IsDir() {
+				return false, nil
+			}
+		} else if e.Type == TREE_FILE {
+			files = append(files, e)
+		} else if e.Type == TREE_LINK && !isLinkValid(path, e) {
+			return false, nil
+		}
+	}
+
+	var outdated atomic.Bool
+	progress := newValidationCounter(len(files), onProgress)
+
+	err := forEachParallel(ctx, files, func(e TreeEntry) error {
+		defer progress.increment()
+
+		// No need to hash the other files, the tree will be staged anyway
+		if outdated.Load() {
+			return nil
+		}
+
+		path := filepath.Join(root, e.Path)
+		if index.VerifyFile(path, e.Download) != nil {
+			outdated.Store(true)
+
+			return nil
+		}
+
+		// Just checking the executable flag
+		if e.Download.Executable {
+			return os.Chmod(path, os.ModePerm)
+		}
+
+		return nil
+	})
+	if err != nil || outdated.Load() {
+		return false, err
+	}
+
+	upToDate := true
+	err = filepath.Walk(root, func(currPath string, fi fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() {
+			return nil
+		}
+
+		if !fileSet[currPath] {
+			fmt.Printf("File / dir %v should not exist.\n", currPath)
+			upToDate = false
+
+			return filepath.SkipAll
+		}
+
+		return nil
+	})
+
+	return upToDate, err
+}
+
+// Fills the staging tree with the files that are already valid
+// either in the staging tree from a previous run, in the live one or in the store
+// and returns the files that are missing
+func stageTree(ctx context.Context, index *FileIndex, store *ObjectStore, tx *Transaction, entries []TreeEntry, onProgress ValidationProgress) ([]Downloadable, error) {
+	fileSet := make(map[string]bool, len(entries))
+	files := []TreeEntry{}
+
+	for _, e := range entries {
+		stagedPath := filepath.Join(tx.Staging, e.Path)
+		fileSet[stagedPath] = true
+
+		if e.Type == TREE_DIRECTORY {
+			if err := os.MkdirAll(stagedPath, os.ModePerm); err != nil {
+				return nil, err
+			}
+		} else if e.Type == TREE_FILE {
+			files = append(files, e)
+		} else if e.Type == TREE_LINK {
+			if err := stageLink(stagedPath, e); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var mtx sync.Mutex
+	filesToDownload := []Downloadable{}
+	progress := newValidationCounter(len(files), onProgress)
+
+	err := forEachParallel(ctx, files, func(e TreeEntry) error {
+		defer progress.increment()
+
+		stagedPath := filepath.Join(tx.Staging, e.Path)
+		livePath := filepath.Join(tx.Root, e.Path)
+
+		f := e.Download
+		f.Path = stagedPath
+
+		if index.VerifyFile(stagedPath, f) != nil {
+			if index.VerifyFile(livePath, f) == nil {
+				if err := linkOrCopy(livePath, stagedPath); err != nil {
+					return err
+				}
+
+				// Hardlinks keep the same inode, but the copy does not
+				if err := index.Record(stagedPath, f, index.Source(livePath)); err != nil {
+					return err
+				}
+
+				// Filling the store with what was installed before it existed
+				if err := store.Add(stagedPath, f); err != nil {
+					fmt.Printf("Failed to add %v to the object store: %v\n", stagedPath, err)
+				}
+			} else if materialized, err := store.Materialize(f, stagedPath); err != nil {
+				return err
+			} else if !materialized {
+				// The installed version can be patched instead of downloading the whole file
+				if exists(livePath) {
+					f.Previous = livePath
+				}
+
+				mtx.Lock()
+				filesToDownload = append(filesToDownload, f)
+				mtx.Unlock()
+
+				return nil
+			}
+		}
+
+		if f.Executable {
+			return os.Chmod(stagedPath, os.ModePerm)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pending := map[string]bool{}
+	for _, f := range filesToDownload {
+		pending[f.Path] = true
+	}
+
+	// Removing the files that should not exist
+	err = filepath.Walk(tx.Staging, func(currPath string, fi fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if fi.IsDir() {
+			return nil
+		}
+
+		// Keeping partial downloads so that they can be resumed
+		// The others would be swapped in with the tree
+		if target, ok := PartialDownloadTarget(currPath); ok && pending[target] {
+			return nil
+		}
+
+		if !fileSet[currPath] {
+			fmt.Printf("File / dir %v should not exist. Removing it.\n", currPath)
+			if err := os.RemoveAll(currPath); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return filesToDownload, err
+}
+
+// A manifest must not be able to create links pointing anywhere on the disk
+// The targets can only go up at their beginning, i.e. "../lib/libjli.dylib"
+// and links can't be inside of other links, so that they are checked without touching the disk
+func checkLinks(entries []TreeEntry) error {
+	links := []string{}
+	for _, e := range entries {
+		if e.Type == TREE_LINK {
+			links = append(links, filepath.Clean(filepath.FromSlash(e.Path))+string(filepath.Separator))
+		}
+	}
+
+	for _, e := range entries {
+		if e.Type != TREE_LINK {
+			continue
+		}
+
+		unsafeErr := fmt.Errorf("%w: %v -> %v", ErrUnsafeLink, e.Path, e.Target)
+
+		// On Windows, "\Windows" is not absolute but still relative to the root of the drive
+		target := filepath.FromSlash(e.Target)
+		if len(target) == 0 || filepath.IsAbs(target) || len(filepath.VolumeName(target)) > 0 || strings.HasPrefix(target, string(filepath.Separator)) {
+			return unsafeErr
+		}
+
+		goingUp := true
+		for _, part := range strings.Split(target, string(filepath.Separator)) {
+			if part != ".." {
+				goingUp = false
+			} else if !goingUp {
+				return unsafeErr
+			}
+		}
+
+		resolved := filepath.Join(filepath.Dir(filepath.FromSlash(e.Path)), target)
+		if resolved == ".." || strings.HasPrefix(resolved, ".."+string(filepath.Separator)) {
+			return unsafeErr
+		}
+
+		for _, link := range links {
+			if strings.HasPrefix(filepath.Clean(filepath.FromSlash(e.Path)), link) {
+				return unsafeErr
+			}
+		}
+	}
+
+	return nil
+}
+
+func isLinkValid(path string, e TreeEntry) bool {
+	target, err := os.Readlink(path)
+
+	return err == nil && target == filepath.FromSlash(e.Target)
+}
+
+// Creates the link, replacing whatever is at its path if it's not the right one
+func stageLink(path string, e TreeEntry) error {
+	if isLinkValid(path, e) {
+		return nil
+	}
+
+	if err := os.RemoveAll(path); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	return os.Symlink(filepath.FromSlash(e.Target), path)
+}
+
+// Runs fn on every item with one goroutine per CPU
+// as hashing is what takes most of the time here
+// Stops early if the context is cancelled
+func forEachParallel[T any](ctx context.Context, items []T, fn func(T) error) error {
+	workers := runtime.NumCPU()
+	if len(items) < workers {
+		workers = len(items)
+	}
+
+	jobs := make(chan T)
+	abort := make(chan struct{})
+
+	var (
+		wg        sync.WaitGroup
+		abortOnce sync.Once
+		firstErr  error
+	)
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for item := range jobs {
+				if err := fn(item); err != nil {
+					abortOnce.Do(func() {
+						firstErr = err
+						close(abort)
+					})
+
+					return
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, item := range items {
+		select {
+		case jobs <- item:
+		case <-abort:
+			break feed
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+
+	wg.Wait()
+
+	if firstErr == nil {
+		return ctx.Err()
+	}
+
+	return firstErr
+}
+
+type validationCounter struct {
+	done       atomic.Int64
+	total      int
+	onProgress ValidationProgress
+}
+
+func newValidationCounter(total int, onProgress ValidationProgress) *validationCounter {
+	return &validationCounter{total: total, onProgress: onProgress}
+}
+
+func (c *validationCounter) increment() {
+	done := c.done.Add(1)
+
+	if c.onProgress != nil {
+		c.onProgress(int(done), c.total)
+	}
+}
+
+// Hardlinks are used so that the unchanged files are not copied
+// but some filesystems don't support them
+func linkOrCopy(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fi.Mode())
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,69 @@
+/**
+ * Spectrum-Bootstrap - A bootstrap for Minecraft launchers
+ * Copyright (C) 2023-2024 - Oxodao
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ **/
+
+package main
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+)
+
+func TestCheckLinks(t *testing.T) {
+	link := func(path, target string) TreeEntry {
+		return TreeEntry{Type: TREE_LINK, Path: path, Target: target}
+	}
+
+	tests := []struct {
+		name    string
+		entries []TreeEntry
+		unsafe  bool
+	}{
+		{"sibling", []TreeEntry{link("bin/java", "javaw")}, false},
+		{"up then down", []TreeEntry{link("bin/java", "../lib/java")}, false},
+		{"up to the root", []TreeEntry{link("a/b/c", "../../d")}, false},
+		{"nested path", []TreeEntry{link("lib/libjli.dylib", "jli/libjli.dylib")}, false},
+		{"same prefix as a link", []TreeEntry{link("lib", "real"), link("libs/x", "../y")}, false},
+		{"file under a link", []TreeEntry{link("lib", "real"), {Type: TREE_FILE, Path: "lib/x"}}, false},
+
+		{"empty", []TreeEntry{link("bin/java", "")}, true},
+		{"above the root", []TreeEntry{link("java", "../java")}, true},
+		{"far above the root", []TreeEntry{link("lib/java", "../../../../etc/passwd")}, true},
+		{"up after a part", []TreeEntry{link("lib/java", "x/../../../etc/passwd")}, true},
+		{"up after a part staying inside", []TreeEntry{link("lib/java", "x/../y")}, true},
+		{"up in the middle", []TreeEntry{link("lib/java", "../x/../y")}, true},
+		{"absolute", []TreeEntry{link("bin/java", "/usr/bin/java")}, true},
+		{"rooted", []TreeEntry{link("bin/java", `\Windows\System32`)}, runtime.GOOS == "windows"},
+		{"volume", []TreeEntry{link("bin/java", "C:/Windows/System32")}, runtime.GOOS == "windows"},
+		{"drive relative", []TreeEntry{link("bin/java", "C:Windows")}, runtime.GOOS == "windows"},
+		{"under a link", []TreeEntry{link("jre", "real"), link("jre/bin/java", "../x")}, true},
+		{"under a link listed after", []TreeEntry{link("jre/bin/java", "x"), link("jre", "real")}, true},
+		{"deep under a link", []TreeEntry{link("jre", "real"), link("jre/a/b/c", "d")}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkLinks(tt.entries)
+			if tt.unsafe && !errors.Is(err, ErrUnsafeLink) {
+				t.Fatalf("expected ErrUnsafeLink, got %v", err)
+			} else if !tt.unsafe && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		})
+	}
+}
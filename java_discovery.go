@@ -0,0 +1,157 @@
+/**
+ * Spectrum-Bootstrap - A bootstrap for Minecraft launchers
+ * Copyright (C) 2023-2024 - Oxodao
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ **/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+)
+
+// Where the runtime of a JvmManager comes from
+const (
+	JAVA_SOURCE_MANIFEST = "manifest"
+	JAVA_SOURCE_SYSTEM   = "system"
+	JAVA_SOURCE_USER     = "user"
+)
+
+// Where the package managers and installers put the JDKs
+var SYSTEM_JAVA_GLOBS = map[string][]string{
+	"linux":   {"/usr/lib/jvm/*", "/usr/lib64/jvm/*", "/opt/java/*"},
+	"darwin":  {"/Library/Java/JavaVirtualMachines/*/Contents/Home"},
+	"windows": {`${ProgramFiles}\Java\*`, `${ProgramFiles}\Eclipse Adoptium\*`, `${ProgramFiles}\Microsoft\jdk-*`, `${ProgramFiles}\Zulu\*`},
+}
+
+// The major versions of Mojang's components, for when their manifest can't be fetched
+var MOJANG_JAVA_COMPONENTS = map[string]string{
+	"jre-legacy":                  "8",
+	"java-runtime-alpha":          "16",
+	"java-runtime-beta":           "17",
+	"java-runtime-gamma":          "17",
+	"java-runtime-gamma-snapshot": "17",
+	"java-runtime-delta":          "21",
+}
+
+var ErrUnknownJavaVersion = errors.New("the required java version is unknown, set jre.version in the launcher manifest")
+
+// The java homes installed on the system: JAVA_HOME, then the java of the PATH, then the usual folders
+func FindSystemJavaHomes() []string {
+	homes := []string{}
+	if javaHome := os.Getenv("JAVA_HOME"); len(javaHome) > 0 {
+		homes = append(homes, javaHome)
+	}
+
+	if javaPath, err := exec.LookPath("java"); err == nil {
+		// i.e. /usr/bin/java -> /etc/alternatives/java -> /usr/lib/jvm/java-17-openjdk-amd64/bin/java
+		if resolved, err := filepath.EvalSymlinks(javaPath); err == nil {
+			javaPath = resolved
+		}
+
+		homes = append(homes, filepath.Dir(filepath.Dir(javaPath)))
+	}
+
+	for _, pattern := range SYSTEM_JAVA_GLOBS[runtime.GOOS] {
+		matches, _ := filepath.Glob(os.ExpandEnv(pattern))
+		homes = append(homes, matches...)
+	}
+
+	unique := []string{}
+	for _, home := range homes {
+		if !slices.Contains(unique, filepath.Clean(home)) {
+			unique = append(unique, filepath.Clean(home))
+		}
+	}
+
+	return unique
+}
+
+// The version the launcher asked for, otherwise the major version of the component
+// on the other os of the cached Java manifest, or the one of Mojang's component
+func RequiredJavaVersion(bs *BootstrapSettings, launcherManifest LauncherJavaManifest) string {
+	if len(launcherManifest.Version) > 0 {
+		return launcherManifest.Version
+	}
+
+	mainManifest, err := LoadFromCache[MainJavaManifest](filepath.Join(bs.LauncherPath, ".cache", "main_java_manifest.json"))
+	if err == nil && mainManifest != nil {
+		for _, components := range *mainManifest {
+			for _, v := range components[launcherManifest.Component] {
+				if version, err := javaSemver(v.Version.Name); err == nil {
+					return strconv.FormatUint(version.Major(), 10)
+				}
+			}
+		}
+	}
+
+	return MOJANG_JAVA_COMPONENTS[launcherManifest.Component]
+}
+
+// Returns a manager for the first of the homes whose version is the required one
+// Nothing is installed, the runtime is used as is
+func GetExternalJvmManager(ctx context.Context, bs *BootstrapSettings, launcherManifest LauncherJavaManifest, homes []string, source string) (*JvmManager, error) {
+	constraint := RequiredJavaVersion(bs, launcherManifest)
+
+	// The player knows what they are doing, not us
+	if len(constraint) == 0 && source != JAVA_SOURCE_USER {
+		return nil, ErrUnknownJavaVersion
+	}
+
+	for _, home := range homes {
+		properties, err := ProbeJava(ctx, filepath.Join(home, "bin", "java"))
+		if err != nil {
+			continue
+		}
+
+		matches, err := matchesJavaConstraint(properties.Version, constraint)
+		if err != nil {
+			return nil, err
+		} else if !matches {
+			continue
+		}
+
+		// JAVA_HOME might be a JDK 8 whose java.home is its jre folder
+		if len(properties.Home) == 0 {
+			properties.Home = home
+		}
+
+		jvmManager := &JvmManager{
+			launcherManifest: launcherManifest,
+			bSettings:        bs,
+			javaHome:         properties.Home,
+			source:           source,
+			os:               GetPlatform(),
+		}
+
+		if osCandidates := GetOsCandidates(jvmManager.os, bs.OsMapping, launcherManifest.OsMapping); len(osCandidates) > 0 {
+			jvmManager.os = osCandidates[0]
+		}
+
+		jvmManager.version.Version.Name = properties.Version
+
+		return jvmManager, nil
+	}
+
+	return nil, fmt.Errorf("%w: %v", ErrNoMatchingJavaVersion, constraint)
+}
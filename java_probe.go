@@ -0,0 +1,183 @@
+/**
+ * Spectrum-Bootstrap - A bootstrap for Minecraft launchers
+ * Copyright (C) 2023-2024 - Oxodao
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ **/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+)
+
+const JAVA_SELF_TEST_TIMEOUT = 30 * time.Second
+
+// The os.arch values of each GOARCH
+var JAVA_ARCHS = map[string][]string{
+	"amd64": {"amd64", "x86_64"},
+	"386":   {"x86", "i386", "i486", "i586", "i686"},
+	"arm64": {"aarch64", "arm64"},
+	"arm":   {"arm", "aarch32"},
+}
+
+type JavaProperties struct {
+	Version string
+	Arch    string
+	Home    string
+}
+
+// Property is empty when java could not be run at all
+type JavaSelfTestError struct {
+	Component string
+	Property  string
+	Expected  string
+	Actual    string
+	Err       error
+}
+
+func (e *JavaSelfTestError) Error() string {
+	if len(e.Property) == 0 {
+		return fmt.Sprintf("the java runtime %v can't be run: %v", e.Component, e.Err)
+	}
+
+	return fmt.Sprintf("the java runtime %v is not the expected one: expected %v %v, got %v", e.Component, e.Property, e.Expected, e.Actual)
+}
+
+func (e *JavaSelfTestError) Unwrap() error {
+	return e.Err
+}
+
+// Runs "java -XshowSettings:properties -version" and reads the properties it prints
+func ProbeJava(ctx context.Context, javaPath string) (*JavaProperties, error) {
+	ctx, cancel := context.WithTimeout(ctx, JAVA_SELF_TEST_TIMEOUT)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, javaPath, "-XshowSettings:properties", "-version").CombinedOutput()
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+
+		if len(bytes.TrimSpace(output)) == 0 {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("%w: %v", err, strings.TrimSpace(string(output)))
+	}
+
+	properties := JavaProperties{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), " = ")
+		if !ok {
+			continue
+		}
+
+		switch key {
+		case "java.version":
+			properties.Version = value
+		case "os.arch":
+			properties.Arch = value
+		case "java.home":
+			properties.Home = value
+		}
+	}
+
+	if len(properties.Version) == 0 {
+		return nil, fmt.Errorf("no java.version in the output: %v", strings.TrimSpace(string(output)))
+	}
+
+	return &properties, nil
+}
+
+// A runtime can match its manifest and still be unusable: missing exec bit, noexec mount, wrong libc, ...
+// Better to tell it now than failing to start the launcher
+func (m *JvmManager) SelfTest(ctx context.Context) error {
+	properties, err := ProbeJava(ctx, m.GetJavaPath())
+	if err != nil {
+		return &JavaSelfTestError{Component: m.launcherManifest.Component, Err: err}
+	}
+
+	if !sameJavaVersion(m.version.Version.Name, properties.Version) {
+		return &JavaSelfTestError{
+			Component: m.launcherManifest.Component,
+			Property:  "java.version",
+			Expected:  m.version.Version.Name,
+			Actual:    properties.Version,
+		}
+	}
+
+	// A fallback key or a runtime that is not ours might be an emulated one, i.e. x64 on Windows arm64
+	archs, known := JAVA_ARCHS[runtime.GOARCH]
+	if !m.osFallback && len(m.javaHome) == 0 && known && !slices.Contains(archs, properties.Arch) {
+		return &JavaSelfTestError{
+			Component: m.launcherManifest.Component,
+			Property:  "os.arch",
+			Expected:  runtime.GOARCH,
+			Actual:    properties.Arch,
+		}
+	}
+
+	if !isInsideDirectory(m.GetPath(), properties.Home) {
+		return &JavaSelfTestError{
+			Component: m.launcherManifest.Component,
+			Property:  "java.home",
+			Expected:  m.GetPath(),
+			Actual:    properties.Home,
+		}
+	}
+
+	return nil
+}
+
+// "8u51" and "1.8.0_51" are the same version
+func sameJavaVersion(name, version string) bool {
+	expected, err := javaSemver(name)
+	if err != nil {
+		return name == version
+	}
+
+	actual, err := javaSemver(version)
+	if err != nil {
+		return name == version
+	}
+
+	return expected.Equal(actual)
+}
+
+// On macOS, java.home is jre.bundle/Contents/Home inside the runtime
+func isInsideDirectory(directory, path string) bool {
+	if resolved, err := filepath.EvalSymlinks(directory); err == nil {
+		directory = resolved
+	}
+
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+
+	rel, err := filepath.Rel(directory, path)
+
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
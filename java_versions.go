@@ -0,0 +1,165 @@
+/**
+ * Spectrum-Bootstrap - A bootstrap for Minecraft launchers
+ * Copyright (C) 2023-2024 - Oxodao
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ **/
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+const INSTALL_ID_FILE = "install_id"
+
+var ErrNoMatchingJavaVersion = errors.New("no java version matches the constraint")
+
+var javaVersionNumbers = regexp.MustCompile(`\d+`)
+
+// Picks the newest version matching the constraint among the ones rolled out to this install
+// The constraint is either the exact name of a version or a semver constraint, i.e. "17" or ">=17, <21"
+func SelectJavaVersion(versions []MainJavaManifestVersion, constraint string, installId string) (*MainJavaManifestVersion, error) {
+	candidates := []MainJavaManifestVersion{}
+	for _, v := range versions {
+		matches, err := matchesJavaConstraint(v.Version.Name, constraint)
+		if err != nil {
+			return nil, err
+		}
+
+		if matches {
+			candidates = append(candidates, v)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("%w: %v", ErrNoMatchingJavaVersion, constraint)
+	}
+
+	rolledOut := slices.DeleteFunc(slices.Clone(candidates), func(v MainJavaManifestVersion) bool {
+		return !isRolledOut(v.Availability, installId)
+	})
+
+	// Better an update that is not meant for us yet than no java at all
+	if len(rolledOut) > 0 {
+		candidates = rolledOut
+	} else {
+		fmt.Printf("No java version matching %q is rolled out to this install yet, using the newest one\n", constraint)
+	}
+
+	slices.SortStableFunc(candidates, func(a, b MainJavaManifestVersion) int {
+		return compareReleases(b.Version.Released, a.Version.Released)
+	})
+
+	return &candidates[0], nil
+}
+
+func matchesJavaConstraint(name, constraint string) (bool, error) {
+	if len(constraint) == 0 || name == constraint {
+		return true, nil
+	}
+
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return false, err
+	}
+
+	version, err := javaSemver(name)
+	if err != nil {
+		return false, nil
+	}
+
+	return c.Check(version), nil
+}
+
+// Java versions are not always semver: "1.8.0_51", "8u51", "16.0.1.9.1"
+func javaSemver(name string) (*semver.Version, error) {
+	name = strings.Replace(name, "u", ".0.", 1)
+	numbers := javaVersionNumbers.FindAllString(name, -1)
+
+	// The old "1.8" naming
+	if len(numbers) > 1 && numbers[0] == "1" {
+		numbers = numbers[1:]
+	}
+
+	for len(numbers) < 3 {
+		numbers = append(numbers, "0")
+	}
+
+	return semver.NewVersion(strings.Join(numbers[:3], "."))
+}
+
+// Mojang-style progressive rollouts, the install is in the rollout
+// when its bucket for the group is below the progress
+// No availability means that the version is available to everyone
+func isRolledOut(availability *JavaAvailability, installId string) bool {
+	if availability == nil {
+		return true
+	}
+
+	return rolloutBucket(installId, availability.Group) < availability.Progress
+}
+
+// A number between 0 and 99, always the same for an install and a group
+func rolloutBucket(installId string, group int) int {
+	sum := sha256.Sum256([]byte(installId + ":" + strconv.Itoa(group)))
+
+	return int(binary.BigEndian.Uint64(sum[:8]) % 100)
+}
+
+// Newest first, the dates that can't be parsed are compared as strings
+func compareReleases(a, b string) int {
+	aTime, aErr := time.Parse(time.RFC3339, a)
+	bTime, bErr := time.Parse(time.RFC3339, b)
+	if aErr != nil || bErr != nil {
+		return strings.Compare(a, b)
+	}
+
+	return aTime.Compare(bTime)
+}
+
+// A random identifier generated on the first start, used for the rollouts
+func GetInstallId(bs *BootstrapSettings) (string, error) {
+	path := filepath.Join(bs.LauncherPath, INSTALL_ID_FILE)
+
+	data, err := os.ReadFile(path)
+	if err == nil && len(strings.TrimSpace(string(data))) > 0 {
+		return strings.TrimSpace(string(data)), nil
+	} else if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+
+	installId := hex.EncodeToString(id)
+
+	return installId, os.WriteFile(path, []byte(installId), 0666)
+}
@@ -19,20 +19,26 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io/fs"
-	"os"
 	"path"
 	"path/filepath"
 	"runtime"
 	"slices"
+	"strings"
+	"time"
 )
 
+// The purpose of the component set in the "component" key of the manifest
+const JAVA_PURPOSE_LAUNCHER = "launcher"
+
 var (
 	ErrFailedDetermineOs  = errors.New("failed to determine os/arch")
 	ErrNoJavaForOs        = errors.New("no java found for this os")
 	ErrNoJavaVersionForOs = errors.New("java version for this os doesn't include the required component")
+	// A component is installed once, in a folder that does not depend on its version
+	ErrConflictingJavaVersions = errors.New("the same java component is required with different versions")
 )
 
 type JvmManager struct {
@@ -40,155 +46,365 @@ type JvmManager struct {
 	cachedVersionManifest *JavaManifest
 
 	launcherManifest LauncherJavaManifest
+	purposes         []string
+	version          MainJavaManifestVersion
 	os               string
+	osFallback       bool
 	bSettings        *BootstrapSettings
+	index            *FileIndex
+	store            *ObjectStore
+	mirrors          []string
+
+	// Where the runtime comes from, only the manifest ones are installed by us
+	source   string
+	javaHome string
+
+	// Only set when the runtime is shared with other brands
+	runtimes    *RuntimeStore
+	runtimeLock *FileLock
+
+	transaction *Transaction
 }
 
-func GetJvmManager(bs *BootstrapSettings, launcherManifest LauncherJavaManifest) (*JvmManager, error) {
-	//#region Detecting os
-	// runtime.GOARCH = 386 amd64 amd64p32 arm arm64
-	os := runtime.GOOS
-	arch := runtime.GOARCH
-	if os == "linux" {
-		os = "linux"
-		if arch == "386" {
-			os += "-i386"
-		} else if arch != "amd64" && arch != "amd64p32" {
-			return nil, ErrFailedDetermineOs
+// Returns a manager for the runtime of the launcher and for every other component it needs
+// A component needed for several purposes is only installed once, they must ask for the same version
+func GetJvmManagers(ctx context.Context, bs *BootstrapSettings, index *FileIndex, store *ObjectStore, launcherManifest LauncherJavaManifest, mirrors []string) ([]*JvmManager, error) {
+	components := append(
+		[]LauncherJavaComponent{{Component: launcherManifest.Component, Purpose: JAVA_PURPOSE_LAUNCHER, Version: launcherManifest.Version}},
+		launcherManifest.Components...,
+	)
+
+	managers := []*JvmManager{}
+	for _, c := range components {
+		idx := slices.IndexFunc(managers, func(m *JvmManager) bool {
+			return m.launcherManifest.Component == c.Component
+		})
+		if idx >= 0 && managers[idx].launcherManifest.Version != c.Version {
+			return nil, fmt.Errorf("%w: %v is required as %q and %q", ErrConflictingJavaVersions, c.Component, managers[idx].launcherManifest.Version, c.Version)
+		} else if idx >= 0 {
+			managers[idx].purposes = append(managers[idx].purposes, c.Purpose)
+
+			continue
 		}
-	} else if os == "darwin" {
-		os = "mac-os"
-		if arch == "arm64" {
-			os += "-arm64"
-		} else if arch != "amd64" {
-			return nil, ErrFailedDetermineOs
+
+		componentManifest := launcherManifest
+		componentManifest.Component = c.Component
+		componentManifest.Version = c.Version
+
+		m, err := getComponentJvmManager(ctx, bs, index, store, componentManifest, mirrors)
+		if err != nil && c.Optional && ctx.Err() == nil {
+			fmt.Printf("Skipping the optional runtime %v: %v\n", c.Component, err)
+
+			continue
+		} else if err != nil {
+			return nil, err
 		}
-	} else if os == "windows" {
-		os = "windows"
-		if arch == "386" {
-			os += "-x86"
-		} else if arch == "amd64" || arch == "amd64p32" {
-			os += "-x64"
-		} else if arch == "arm64" {
-			os += "-arm64"
-		} else {
-			return nil, ErrFailedDetermineOs
+
+		m.purposes = []string{c.Purpose}
+		managers = append(managers, m)
+	}
+
+	// The shared runtimes are locked in this order, it has to be the same for every bootstrap
+	slices.SortFunc(managers, func(a, b *JvmManager) int {
+		return strings.Compare(a.GetPath(), b.GetPath())
+	})
+
+	return managers, nil
+}
+
+// The java home of the settings is used when it has the required version
+// The system ones are only used when the one of the manifest can't be
+func getComponentJvmManager(ctx context.Context, bs *BootstrapSettings, index *FileIndex, store *ObjectStore, launcherManifest LauncherJavaManifest, mirrors []string) (*JvmManager, error) {
+	if len(bs.JavaHome) > 0 {
+		m, err := GetExternalJvmManager(ctx, bs, launcherManifest, []string{bs.JavaHome}, JAVA_SOURCE_USER)
+		if err == nil {
+			fmt.Printf("Using the java %v of %v for %v\n", m.version.Version.Name, m.GetPath(), launcherManifest.Component)
+
+			return m, nil
+		}
+
+		fmt.Printf("Not using %v for %v: %v\n", bs.JavaHome, launcherManifest.Component, err)
+	}
+
+	m, err := GetJvmManager(ctx, bs, index, store, launcherManifest, mirrors)
+	if err == nil || ctx.Err() != nil {
+		return m, err
+	}
+
+	// The manifest can't be fetched, or has nothing for this os
+	systemManager, systemErr := GetExternalJvmManager(ctx, bs, launcherManifest, FindSystemJavaHomes(), JAVA_SOURCE_SYSTEM)
+	if systemErr != nil {
+		fmt.Printf("No system java can be used for %v: %v\n", launcherManifest.Component, systemErr)
+
+		return nil, err
+	}
+
+	fmt.Printf("Using the system java %v of %v for %v: %v\n", systemManager.version.Version.Name, systemManager.GetPath(), launcherManifest.Component, err)
+
+	return systemManager, nil
+}
+
+func FindJvmManager(managers []*JvmManager, purpose string) *JvmManager {
+	for _, m := range managers {
+		if slices.Contains(m.purposes, purpose) {
+			return m
 		}
-	} else {
-		return nil, ErrFailedDetermineOs
 	}
-	//#endregion
+
+	return nil
+}
+
+// The mirrors are the ones from the launcher manifest
+// so that a self-hosted Java manifest can be mirrored too
+func GetJvmManager(ctx context.Context, bs *BootstrapSettings, index *FileIndex, store *ObjectStore, launcherManifest LauncherJavaManifest, mirrors []string) (*JvmManager, error) {
+	platform := GetPlatform()
+	osCandidates := GetOsCandidates(platform, bs.OsMapping, launcherManifest.OsMapping)
+	if len(osCandidates) == 0 {
+		return nil, fmt.Errorf("%w: %v", ErrFailedDetermineOs, platform)
+	}
 
 	jvmManager := &JvmManager{
 		launcherManifest: launcherManifest,
 		bSettings:        bs,
-		os:               os,
+		index:            index,
+		store:            store,
+		mirrors:          mirrors,
+		source:           JAVA_SOURCE_MANIFEST,
+	}
+
+	if bs.SharedRuntime {
+		sharedPath, err := GetSharedDirectory()
+		if err != nil {
+			return nil, err
+		}
+
+		jvmManager.runtimes = GetRuntimeStore(sharedPath, bs.LauncherPath)
 	}
 
 	// We load the main manifest
-	mainManifest, err := GetOrCached[MainJavaManifest](
+	mainManifest, mainManifestUrl, err := GetOrCached[MainJavaManifest](
+		ctx,
 		bs,
 		filepath.Join(bs.LauncherPath, ".cache", "main_java_manifest.json"),
-		launcherManifest.ManifestURL,
+		ExpandMirrors(mirrors, launcherManifest.ManifestURL),
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	for _, components := range *mainManifest {
+		for _, versions := range components {
+			for i := range versions {
+				versions[i].Manifest.Url = ResolveUrl(mainManifestUrl, versions[i].Manifest.Url)
+			}
+		}
+	}
+
 	jvmManager.cachedMainManifest = mainManifest
 
 	// We load the manifest for the os/version
-	versions, ok := (*jvmManager.cachedMainManifest)[os]
-	if !ok {
-		return nil, ErrNoJavaForOs
+	// The first key of the mapping having the component is used
+	var componentVersions []MainJavaManifestVersion
+	err = ErrNoJavaForOs
+	for i, os := range osCandidates {
+		versions, ok := (*jvmManager.cachedMainManifest)[os]
+		if !ok {
+			continue
+		}
+
+		// Mojang's manifest lists every component for every os, even the ones it does not have
+		if len(versions[launcherManifest.Component]) == 0 {
+			err = ErrNoJavaVersionForOs
+
+			continue
+		}
+
+		componentVersions, err = versions[launcherManifest.Component], nil
+		jvmManager.os = os
+		jvmManager.osFallback = i > 0
+
+		break
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", err, platform)
+	}
+
+	installId, err := GetInstallId(bs)
+	if err != nil {
+		return nil, err
 	}
 
-	version, ok := versions[launcherManifest.Component]
-	if !ok {
-		return nil, ErrNoJavaVersionForOs
+	version, err := SelectJavaVersion(componentVersions, launcherManifest.Version, installId)
+	if err != nil {
+		return nil, err
 	}
-	versionManifest, err := GetOrCached[JavaManifest](
+	jvmManager.version = *version
+
+	versionManifest, versionManifestUrl, err := GetOrCached[JavaManifest](
+		ctx,
 		bs,
-		filepath.Join(bs.LauncherPath, ".cache", "java_"+os+"_"+launcherManifest.Component+".json"),
-		version[0].Manifest.Url, // @TODO: Check how versions are handled, should we DL the first or the last?
+		filepath.Join(bs.LauncherPath, ".cache", "java_"+jvmManager.os+"_"+launcherManifest.Component+".json"),
+		ExpandMirrors(mirrors, version.Manifest.Url),
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	for k, v := range versionManifest.Files {
+		v.Downloads.Raw.Url = ResolveUrl(versionManifestUrl, v.Downloads.Raw.Url)
+		v.Downloads.LZMA.Url = ResolveUrl(versionManifestUrl, v.Downloads.LZMA.Url)
+		versionManifest.Files[k] = v
+	}
+
 	jvmManager.cachedVersionManifest = versionManifest
 
 	return jvmManager, nil
 }
 
 func (m *JvmManager) GetPath() string {
+	if len(m.javaHome) > 0 {
+		return m.javaHome
+	}
+
+	if m.runtimes != nil {
+		return m.runtimes.RuntimePath(m.launcherManifest.ManifestURL, m.launcherManifest.Component, m.version.Version.Name, m.os)
+	}
+
 	return path.Join(m.bSettings.LauncherPath, "runtime", m.launcherManifest.Component, m.os)
 }
 
+// The java binary of the runtime
+func (m *JvmManager) GetJavaPath() string {
+	executablePath := "bin/java"
+	if runtime.GOOS == "darwin" && len(m.javaHome) == 0 {
+		executablePath = "jre.bundle/Contents/Home/bin/java"
+	} else if runtime.GOOS == "windows" {
+		executablePath = "bin/javaw.exe"
+	}
+
+	return filepath.Join(m.GetPath(), executablePath)
+}
+
 // Returns a list of files to re-download
-func (m *JvmManager) ValidateInstallation() ([]Downloadable, error) {
-	bp := m.GetPath()
+// They are downloaded in a staging tree that is swapped by Commit()
+func (m *JvmManager) ValidateInstallation(ctx context.Context, onProgress ValidationProgress) ([]Downloadable, error) {
+	// Not ours to update
+	if len(m.javaHome) > 0 {
+		return nil, nil
+	}
 
-	filesToDownload := []Downloadable{}
-	fileList := []string{}
+	entries := []TreeEntry{}
 
 	for k, v := range m.cachedVersionManifest.Files {
-		file := filepath.Join(bp, k)
-		fileList = append(fileList, file)
-
 		if v.Type == "directory" {
-			err := os.MkdirAll(file, os.ModePerm)
-			if err != nil {
-				return nil, err
-			}
+			entries = append(entries, TreeEntry{Type: TREE_DIRECTORY, Path: k})
 		} else if v.Type == "file" {
-			_, err := os.Stat(file)
-			if !os.IsNotExist(err) {
-				sha1 := GetHashSha1(file)
-				if sha1 == v.Downloads.Raw.Hash {
-					// The file exists and has the correct hash
-					// No need to redownload
-
-					// Just checking the executable flag
-					if v.Executable {
-						err := os.Chmod(file, os.ModePerm)
-						if err != nil {
-							return nil, err
-						}
-					}
-					continue
-				}
-			}
-
-			filesToDownload = append(filesToDownload, Downloadable{
-				Url:        v.Downloads.Raw.Url,
-				Path:       file,
-				Sha1:       v.Downloads.Raw.Hash,
-				Size:       v.Downloads.Raw.Size,
-				Executable: v.Executable,
+			entries = append(entries, TreeEntry{
+				Type: TREE_FILE,
+				Path: k,
+				Download: Downloadable{
+					Urls:       ExpandMirrors(m.mirrors, v.Downloads.Raw.Url),
+					Sha1:       v.Downloads.Raw.Hash,
+					Size:       v.Downloads.Raw.Size,
+					Executable: v.Executable,
+					Compressed: m.lzmaDownloadable(v),
+				},
 			})
+		} else if v.Type == "link" {
+			entries = append(entries, TreeEntry{Type: TREE_LINK, Path: k, Target: v.Target})
 		}
 	}
 
-	// Removing the files that should not exist
-	err := filepath.Walk(bp, func(currPath string, fi fs.FileInfo, err error) error {
+	// Kept until ReferenceRuntimes() or Release(), so that no other bootstrap touches the shared runtime meanwhile
+	if m.runtimes != nil && m.runtimeLock == nil {
+		lock, err := m.runtimes.LockRuntime(ctx, m.GetPath())
 		if err != nil {
+			return nil, err
+		}
+		m.runtimeLock = lock
+	}
+
+	tx, filesToDownload, err := ValidateTree(ctx, m.index, m.store, m.GetPath(), entries, onProgress)
+	m.transaction = tx
+
+	return filesToDownload, err
+}
+
+// The LZMA file is about half the size of the raw one
+// It is checked against the hash of the raw file once decompressed
+func (m *JvmManager) lzmaDownloadable(f JavaManifestFile) *CompressedDownloadable {
+	if len(f.Downloads.LZMA.Url) == 0 {
+		return nil
+	}
+
+	return &CompressedDownloadable{
+		Format: COMPRESSION_LZMA,
+		Urls:   ExpandMirrors(m.mirrors, f.Downloads.LZMA.Url),
+	}
+}
+
+// Swaps the updated runtime in, once every file has been downloaded
+func (m *JvmManager) Commit() error {
+	if m.transaction != nil {
+		if err := m.transaction.Commit(); err != nil {
 			return err
 		}
 
-		if fi.IsDir() {
-			return nil
+		m.index.MovePrefix(m.transaction.Staging, m.transaction.Root)
+	}
+
+	// Not being able to tell the launcher does not prevent it from starting
+	err := UpdateRuntimeRegistry(m.bSettings, RuntimeRegistryEntry{
+		Component:  m.launcherManifest.Component,
+		Purposes:   m.purposes,
+		Os:         m.os,
+		Version:    m.version.Version.Name,
+		Released:   m.version.Version.Released,
+		Path:       m.GetPath(),
+		Java:       m.GetJavaPath(),
+		Source:     m.source,
+		VerifiedAt: time.Now(),
+	})
+	if err != nil {
+		fmt.Println("Failed to update the runtime registry:", err)
+	}
+
+	return nil
+}
+
+// Makes the shared runtimes the ones used by this launcher, once every one of them is committed
+// They are referenced at once so that the ones of the other components are not collected
+func ReferenceRuntimes(managers []*JvmManager) error {
+	var store *RuntimeStore
+	runtimePaths := []string{}
+	for _, m := range managers {
+		if m.runtimes != nil {
+			store = m.runtimes
+			runtimePaths = append(runtimePaths, m.GetPath())
 		}
+	}
 
-		if !slices.Contains(fileList, currPath) {
-			fmt.Printf("File / dir %v should not exist. Removing it.\n", currPath)
-			if err := os.RemoveAll(currPath); err != nil {
-				return err
-			}
+	// Even when up-to-date, another brand might have installed them
+	// When they can't be referenced, they stay locked until we exit so that they are not removed while being used
+	if store != nil {
+		if err := store.Reference(context.Background(), runtimePaths); err != nil {
+			return err
 		}
+	}
 
-		return nil
-	})
+	for _, m := range managers {
+		if err := m.Release(); err != nil {
+			return err
+		}
+	}
 
-	return filesToDownload, err
+	return nil
+}
+
+// Lets the other bootstraps use the shared runtime
+func (m *JvmManager) Release() error {
+	lock := m.runtimeLock
+	m.runtimeLock = nil
+
+	return lock.Release()
 }
@@ -19,106 +19,165 @@
 package main
 
 import (
-	"fmt"
-	"io/fs"
-	"os"
+	"context"
 	"path"
 	"path/filepath"
-	"slices"
+	"strings"
 )
 
 type LauncherManager struct {
 	launcherManifest *LauncherManifest
 	bSettings        *BootstrapSettings
+	index            *FileIndex
+	store            *ObjectStore
+
+	transaction *Transaction
 }
 
-func GetLauncherManager(bs *BootstrapSettings) (*LauncherManager, error) {
+func GetLauncherManager(ctx context.Context, bs *BootstrapSettings, index *FileIndex, store *ObjectStore) (*LauncherManager, error) {
 	launcherManager := &LauncherManager{
 		bSettings: bs,
+		index:     index,
+		store:     store,
 	}
 
 	// We load the main manifest
-	mainManifest, err := GetOrCached[LauncherManifest](
+	mainManifest, manifestUrl, err := GetOrCached[LauncherManifest](
+		ctx,
 		bs,
 		filepath.Join(bs.LauncherPath, ".cache", "launcher_manifest.json"),
-		bs.ManifestURL,
+		append([]string{bs.ManifestURL}, bs.ManifestMirrors...),
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	resolveLauncherManifest(mainManifest, manifestUrl)
 	launcherManager.launcherManifest = mainManifest
 
 	return launcherManager, nil
 }
 
+// Makes every url of the manifest absolute
+// They are relative to base_url if set, which is itself relative to where the manifest was fetched
+func resolveLauncherManifest(manifest *LauncherManifest, manifestUrl string) {
+	base := manifestUrl
+	if len(manifest.BaseUrl) > 0 {
+		baseUrl := manifest.BaseUrl
+		if !strings.HasSuffix(baseUrl, "/") {
+			baseUrl += "/"
+		}
+
+		base = ResolveUrl(manifestUrl, baseUrl)
+	}
+
+	for i := range manifest.Mirrors {
+		manifest.Mirrors[i] = ResolveUrl(manifestUrl, manifest.Mirrors[i])
+	}
+
+	for i := range manifest.Files {
+		f := &manifest.Files[i]
+
+		f.Url = ResolveUrl(base, f.Url)
+		for j := range f.Urls {
+			f.Urls[j] = ResolveUrl(base, f.Urls[j])
+		}
+
+		if f.Compressed != nil {
+			f.Compressed.Url = ResolveUrl(base, f.Compressed.Url)
+			for j := range f.Compressed.Urls {
+				f.Compressed.Urls[j] = ResolveUrl(base, f.Compressed.Urls[j])
+			}
+		}
+
+		for j := range f.Patches {
+			p := &f.Patches[j]
+
+			p.Url = ResolveUrl(base, p.Url)
+			for k := range p.Urls {
+				p.Urls[k] = ResolveUrl(base, p.Urls[k])
+			}
+		}
+	}
+
+	manifest.Java.ManifestURL = ResolveUrl(base, manifest.Java.ManifestURL)
+}
+
 func (m *LauncherManager) GetPath() string {
 	return path.Join(m.bSettings.LauncherPath, "launcher")
 }
 
 // Returns a list of files to re-download
-func (m *LauncherManager) ValidateInstallation() ([]Downloadable, error) {
-	bp := m.GetPath()
-	os.MkdirAll(bp, os.ModePerm)
-
-	filesToDownload := []Downloadable{}
-	fileList := []string{}
+// They are downloaded in a staging tree that is swapped by Commit()
+func (m *LauncherManager) ValidateInstallation(ctx context.Context, onProgress ValidationProgress) ([]Downloadable, error) {
+	entries := []TreeEntry{}
 
 	for _, v := range m.launcherManifest.Files {
-		file := filepath.Join(bp, v.Path)
-		fileList = append(fileList, file)
-
 		if v.Type == "directory" {
-			err := os.MkdirAll(file, os.ModePerm)
-			if err != nil {
-				return nil, err
-			}
+			entries = append(entries, TreeEntry{Type: TREE_DIRECTORY, Path: v.Path})
 		} else if v.Type == "file" || v.Type == "classpath" {
-			_, err := os.Stat(file)
-			if !os.IsNotExist(err) {
-				hash := GetHash(file)
-				if hash == v.Hash {
-					// The file exists and has the correct hash
-					// No need to redownload
-					continue
-				}
-			}
-
-			filesToDownload = append(filesToDownload, Downloadable{
-				Url:        v.Url,
-				Path:       file,
-				Sha256:     v.Hash,
-				Size:       v.Size,
-				Executable: false,
-				// @TODO Maybe later, but there should no need to have an executable
-				// Unless we want to support Java in other languages
-				// Like go which produces direct executables or python
-				// Maybe really later
-				// This could lead this bootstrap to be more generic
-				// instead of a Minecraft focused thing
+			entries = append(entries, TreeEntry{
+				Type: TREE_FILE,
+				Path: v.Path,
+				Download: Downloadable{
+					Urls:       ExpandMirrors(m.launcherManifest.Mirrors, append([]string{v.Url}, v.Urls...)...),
+					Sha256:     v.Hash,
+					Size:       v.Size,
+					Compressed: m.compressedDownloadable(v.Compressed),
+					Patches:    m.patchDownloadables(v.Patches),
+					Executable: false,
+					// @TODO Maybe later, but there should no need to have an executable
+					// Unless we want to support Java in other languages
+					// Like go which produces direct executables or python
+					// Maybe really later
+					// This could lead this bootstrap to be more generic
+					// instead of a Minecraft focused thing
+				},
 			})
 		}
 	}
 
-	// Removing the files that should not exist
-	err := filepath.Walk(bp, func(currPath string, fi fs.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+	tx, filesToDownload, err := ValidateTree(ctx, m.index, m.store, m.GetPath(), entries, onProgress)
+	m.transaction = tx
 
-		if fi.IsDir() {
-			return nil
-		}
+	return filesToDownload, err
+}
 
-		if !slices.Contains(fileList, currPath) {
-			fmt.Printf("File / dir %v should not exist. Removing it.\n", currPath)
-			if err := os.RemoveAll(currPath); err != nil {
-				return err
-			}
-		}
+func (m *LauncherManager) compressedDownloadable(c *ManifestFileCompressed) *CompressedDownloadable {
+	if c == nil {
+		return nil
+	}
+
+	return &CompressedDownloadable{
+		Format: c.Format,
+		Urls:   ExpandMirrors(m.launcherManifest.Mirrors, append([]string{c.Url}, c.Urls...)...),
+	}
+}
+
+func (m *LauncherManager) patchDownloadables(patches []ManifestFilePatch) []PatchDownloadable {
+	downloadables := []PatchDownloadable{}
+	for _, p := range patches {
+		downloadables = append(downloadables, PatchDownloadable{
+			From:   p.From,
+			Format: p.Format,
+			Urls:   ExpandMirrors(m.launcherManifest.Mirrors, append([]string{p.Url}, p.Urls...)...),
+		})
+	}
+
+	return downloadables
+}
 
+// Swaps the updated launcher in, once every file has been downloaded
+func (m *LauncherManager) Commit() error {
+	if m.transaction == nil {
 		return nil
-	})
+	}
 
-	return filesToDownload, err
+	if err := m.transaction.Commit(); err != nil {
+		return err
+	}
+
+	m.index.MovePrefix(m.transaction.Staging, m.transaction.Root)
+
+	return nil
 }
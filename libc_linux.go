@@ -0,0 +1,56 @@
+//go:build linux
+
+/**
+ * Spectrum-Bootstrap - A bootstrap for Minecraft launchers
+ * Copyright (C) 2023-2024 - Oxodao
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ **/
+
+package main
+
+import (
+	"debug/elf"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// The runtimes built against glibc don't work with musl
+// The interpreter of /bin/sh tells which one the system uses,
+// only looking for the musl loader would be wrong on the glibc distributions having it installed
+func isMusl() bool {
+	f, err := elf.Open("/bin/sh")
+	if err != nil {
+		matches, _ := filepath.Glob("/lib/ld-musl-*.so.1")
+
+		return len(matches) > 0
+	}
+	defer f.Close()
+
+	for _, prog := range f.Progs {
+		if prog.Type != elf.PT_INTERP {
+			continue
+		}
+
+		interpreter, err := io.ReadAll(prog.Open())
+		if err != nil {
+			return false
+		}
+
+		return strings.Contains(string(interpreter), "musl")
+	}
+
+	return false
+}
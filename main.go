@@ -19,19 +19,19 @@
 package main
 
 import (
+	"context"
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
-	"log"
-	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"fyne.io/fyne/v2"
@@ -44,11 +44,19 @@ import (
 var BOOTSTRAP_SETTINGS_STR []byte
 
 var basepath *string
+var manifestUrl *string
+var maxDownloads *int
+var fullVerify *bool
+var javaHome *string
 
 var BOOTSTRAP_VERSION = "1"
 
 func init() {
 	basepath = flag.String("path", "", "The path to store launcher data (i.e. portable-mode)")
+	manifestUrl = flag.String("manifest", "", "The url or path of the launcher manifest to use instead of the embedded one")
+	fullVerify = flag.Bool("full-verify", false, "Hash every installed file instead of trusting the file index")
+	maxDownloads = flag.Int("max-downloads", 0, "The maximum amount of files downloaded at once")
+	javaHome = flag.String("java-home", "", "The Java home to use instead of the downloaded runtimes, when it has the required version")
 }
 
 func main() {
@@ -66,7 +74,24 @@ func main() {
 	window := app.NewWindow("SpectrumBootstrap")
 	window.SetFixedSize(true)
 
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+
+	// Stopping everything before quitting so that the installation is left
+	// in a state that can be resumed on the next start
+	stop := func() {
+		cancel()
+
+		go func() {
+			<-stopped
+			app.Quit()
+		}()
+	}
+	window.SetCloseIntercept(stop)
+
 	go func() {
+		defer close(stopped)
+
 		window.SetContent(
 			container.NewVBox(
 				widget.NewLabel(Localize("fetching_launcher_updates", nil)),
@@ -87,8 +112,23 @@ func main() {
 			return
 		}
 
+		// Portable mode should not leave anything outside of its folder
 		if len(*basepath) > 0 {
 			settings.LauncherPath = *basepath
+			settings.SharedRuntime = false
+		}
+
+		if len(*manifestUrl) > 0 {
+			settings.ManifestURL = *manifestUrl
+			settings.ManifestMirrors = nil
+		}
+
+		if *maxDownloads > 0 {
+			settings.MaxDownloads = *maxDownloads
+		}
+
+		if len(*javaHome) > 0 {
+			settings.JavaHome = *javaHome
 		}
 
 		settings.LauncherPath, err = GetLauncherDirectory(&settings)
@@ -105,7 +145,22 @@ func main() {
 
 		window.SetTitle(settings.Brand + " - Bootstrap")
 
-		launcherManager, err := GetLauncherManager(&settings)
+		index := LoadFileIndex(&settings, *fullVerify)
+		defer index.Save()
+
+		// Shared between every brand, unless running in portable mode
+		storePath := filepath.Join(settings.LauncherPath, "objects")
+		if len(*basepath) == 0 {
+			sharedPath, err := GetSharedDirectory()
+			if err == nil {
+				storePath = filepath.Join(sharedPath, "objects")
+			} else {
+				fmt.Println("Failed to find the shared directory:", err)
+			}
+		}
+		store := GetObjectStore(storePath, index)
+
+		launcherManager, err := GetLauncherManager(ctx, &settings, index, store)
 		if err != nil {
 			window.SetContent(
 				container.NewVBox(
@@ -117,7 +172,7 @@ func main() {
 			return
 		}
 
-		jvmManager, err := GetJvmManager(&settings, launcherManager.launcherManifest.Java)
+		jvmManagers, err := GetJvmManagers(ctx, &settings, index, store, launcherManager.launcherManifest.Java, launcherManager.launcherManifest.Mirrors)
 		if err != nil {
 			window.SetContent(
 				container.NewVBox(
@@ -129,19 +184,58 @@ func main() {
 			return
 		}
 
-		jvmFilesToDownload, err := jvmManager.ValidateInstallation()
-		if err != nil {
-			window.SetContent(
-				container.NewVBox(
-					widget.NewLabel(Localize("failed_init", map[string]string{"Err": err.Error()})),
-				),
-			)
-			window.CenterOnScreen()
+		for _, m := range jvmManagers {
+			defer m.Release()
+		}
 
-			return
+		jvmManager := FindJvmManager(jvmManagers, JAVA_PURPOSE_LAUNCHER)
+
+		validationLabel := widget.NewLabel("-")
+		validationProgressBar := widget.NewProgressBar()
+
+		validationContent := container.NewVBox(
+			widget.NewLabel(Localize("verifying_files", nil)),
+			validationLabel,
+			validationProgressBar,
+		)
+
+		// So that the player knows their java is used
+		for _, m := range jvmManagers {
+			if m.source != JAVA_SOURCE_MANIFEST {
+				validationContent.Add(widget.NewLabel(Localize("using_"+m.source+"_java", map[string]string{
+					"Component": m.launcherManifest.Component,
+					"Version":   m.version.Version.Name,
+					"Path":      m.GetPath(),
+				})))
+			}
+		}
+
+		window.SetContent(validationContent)
+		window.CenterOnScreen()
+
+		onValidationProgress := func(done, total int) {
+			validationLabel.SetText(fmt.Sprintf("%v/%v", done, total))
+			validationProgressBar.SetValue(float64(done) / float64(total))
 		}
 
-		launcherFilesToDownload, err := launcherManager.ValidateInstallation()
+		jvmFilesToDownload := []Downloadable{}
+		for _, m := range jvmManagers {
+			files, err := m.ValidateInstallation(ctx, onValidationProgress)
+			if err != nil {
+				window.SetContent(
+					container.NewVBox(
+						widget.NewLabel(Localize("failed_init", map[string]string{"Err": err.Error()})),
+					),
+				)
+				window.CenterOnScreen()
+
+				return
+			}
+
+			jvmFilesToDownload = append(jvmFilesToDownload, files...)
+		}
+
+		launcherFilesToDownload, err := launcherManager.ValidateInstallation(ctx, onValidationProgress)
 		if err != nil {
 			window.SetContent(
 				container.NewVBox(
@@ -156,84 +250,115 @@ func main() {
 		filesToDownload := append(jvmFilesToDownload, launcherFilesToDownload...)
 
 		timeLabel := widget.NewLabel("00:00:00")
+		remainingLabel := widget.NewLabel("-")
+		bytesLabel := widget.NewLabel("-")
 		mainProgressBar := widget.NewProgressBar()
 
-		// @TODO Make this base on goroutine to download multiple file at once
-		// @TODO which will be hard to display properly like SKCraft
-		filenameLabel := widget.NewLabel("-")
-		fileProgressBar := widget.NewProgressBar()
+		downloader := GetDownloader(&settings, index, store)
+		downloader.Progress = NewProgressReporter(filesToDownload)
 
-		window.SetContent(container.NewVBox(
+		amtFiles := len(filesToDownload)
+		amtWorkers := downloader.WorkerCount(filesToDownload)
+
+		// One filename / progress bar couple per worker, like SKCraft does
+		filenameLabels := make([]*widget.Label, amtWorkers)
+		fileProgressBars := make([]*widget.ProgressBar, amtWorkers)
+
+		// Written by the workers, rendered by the ticker so that we don't redraw on every read
+		workerFiles := make([]atomic.Pointer[Downloadable], amtWorkers)
+		workerBytes := make([]atomic.Int64, amtWorkers)
+
+		content := container.NewVBox(
 			widget.NewLabel(Localize("downloading", nil)),
 			container.NewHBox(
 				widget.NewLabel(Localize("elapsed_time", nil)),
 				timeLabel,
 			),
+			container.NewHBox(
+				widget.NewLabel(Localize("remaining_time", nil)),
+				remainingLabel,
+			),
+			bytesLabel,
 			mainProgressBar,
-			filenameLabel,
-			fileProgressBar,
+		)
+
+		for i := 0; i < amtWorkers; i++ {
+			filenameLabels[i] = widget.NewLabel("-")
+			fileProgressBars[i] = widget.NewProgressBar()
+
+			content.Add(filenameLabels[i])
+			content.Add(fileProgressBars[i])
+		}
+
+		pauseButton := widget.NewButton(Localize("pause_button", nil), nil)
+		pauseButton.OnTapped = func() {
+			if downloader.IsPaused() {
+				downloader.Resume()
+				pauseButton.SetText(Localize("pause_button", nil))
+			} else {
+				downloader.Pause()
+				pauseButton.SetText(Localize("resume_button", nil))
+			}
+		}
+
+		content.Add(container.NewHBox(
+			pauseButton,
+			widget.NewButton(Localize("cancel_button", nil), stop),
 		))
 
-		start := time.Now()
-		amtFiles := len(filesToDownload)
-		processedFiles := 0
-		for _, f := range filesToDownload {
-			err := os.MkdirAll(filepath.Dir(f.Path), os.ModePerm)
-			if err != nil {
-				window.SetContent(
-					container.NewVBox(
-						widget.NewLabel(Localize("fail_download", map[string]string{"Err": err.Error()})),
-					),
-				)
-				window.CenterOnScreen()
+		window.SetContent(content)
+		window.CenterOnScreen()
 
-				return
+		var processedFiles atomic.Int64
+
+		render := func() {
+			snapshot := downloader.Progress.Snapshot()
+
+			timeLabel.SetText(fmt.Sprintf("%v (%v/%v)", FormatDuration(snapshot.Elapsed), processedFiles.Load(), amtFiles))
+
+			if snapshot.ETA > 0 {
+				remainingLabel.SetText(FormatDuration(snapshot.ETA))
+			} else {
+				remainingLabel.SetText("-")
 			}
 
-			out, err := os.Create(f.Path)
-			if ShowError(window, "fail_download", err) {
-				return
+			bytesLabel.SetText(fmt.Sprintf(
+				"%v / %v (%v/s)",
+				FormatBytes(snapshot.DoneBytes),
+				FormatBytes(snapshot.TotalBytes),
+				FormatBytes(int64(snapshot.BytesPerSecond)),
+			))
+
+			if snapshot.TotalBytes > 0 {
+				mainProgressBar.SetValue(float64(snapshot.DoneBytes) / float64(snapshot.TotalBytes))
+			} else if amtFiles > 0 {
+				mainProgressBar.SetValue(float64(processedFiles.Load()) / float64(amtFiles))
 			}
 
-			done := make(chan int64)
-			go func(f Downloadable) {
-				var stop bool = false
-
-				for {
-					select {
-					case <-done:
-						stop = true
-					default:
-						fi, err := os.Stat(f.Path)
-						if err != nil {
-							log.Fatal(err)
-						}
-
-						currSize := fi.Size()
-						if currSize == 0 {
-							currSize = 1
-						}
-
-						fileProgressBar.SetValue(float64(currSize) / float64(f.Size))
-
-						duration := time.Since(start).Round(time.Second)
-						hours := duration / time.Hour
-						duration -= hours * time.Hour
-						minutes := duration / time.Minute
-						duration -= minutes * time.Minute
-						seconds := duration / time.Second
-
-						timeLabel.SetText(fmt.Sprintf("%02d:%02d:%02d (%v/%v)", hours, minutes, seconds, processedFiles, amtFiles))
-					}
-
-					if stop {
-						break
-					}
-
-					time.Sleep(time.Second)
+			for i := 0; i < amtWorkers; i++ {
+				f := workerFiles[i].Load()
+				if f != nil && f.Size > 0 {
+					fileProgressBars[i].SetValue(float64(workerBytes[i].Load()) / float64(f.Size))
 				}
-			}(f)
+			}
+		}
+
+		done := make(chan struct{})
+		go func() {
+			ticker := time.NewTicker(250 * time.Millisecond)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					render()
+				}
+			}
+		}()
 
+		downloader.OnFileStart = func(worker int, f Downloadable) {
 			dlFilePath := strings.TrimPrefix(
 				f.Path,
 				settings.LauncherPath,
@@ -241,58 +366,114 @@ func main() {
 			if len(dlFilePath) > 20 {
 				dlFilePath = "..." + dlFilePath[len(dlFilePath)-20:]
 			}
-			filenameLabel.SetText(dlFilePath)
 
+			workerFiles[worker].Store(&f)
+			workerBytes[worker].Store(0)
+			filenameLabels[worker].SetText(dlFilePath)
+		}
+
+		downloader.OnFileProgress = func(worker int, f Downloadable, written int64) {
+			workerBytes[worker].Store(written)
+		}
+
+		downloader.OnFileDone = func(worker int, f Downloadable) {
+			processedFiles.Add(1)
+		}
+
+		err = downloader.Download(ctx, filesToDownload)
+		close(done)
+		render()
+
+		// Cancelled by the user, we're about to quit
+		if ctx.Err() != nil {
+			return
+		}
+
+		var corruptedErr *CorruptedFileError
+		if errors.As(err, &corruptedErr) {
+			window.SetContent(
+				container.NewVBox(
+					widget.NewLabel(Localize("hash_not_match", nil)),
+					widget.NewLabel(Localize("newly_corrupted", map[string]string{
+						"File": displayPath(&settings, launcherManager, jvmManagers, corruptedErr.Path),
+					})),
+				),
+			)
 			window.CenterOnScreen()
 
-			// @TODO: 3 Retries per file
-			req, err := http.NewRequest("GET", f.Url, nil)
-			if ShowError(window, "fail_download", err) {
-				return
-			}
+			return
+		}
 
-			req.Header.Set("User-Agent", "SpectrumBootstrap/"+BOOTSTRAP_VERSION)
+		if ShowError(window, "fail_download", err) {
+			return
+		}
 
-			resp, err := http.DefaultClient.Do(req)
-			if ShowError(window, "fail_download", err) {
+		// Everything is there, we can swap the new files in
+		for _, m := range jvmManagers {
+			if ShowError(window, "fail_install", m.Commit()) {
 				return
 			}
-			defer resp.Body.Close()
+		}
 
-			n, err := io.Copy(out, resp.Body)
-			if ShowError(window, "fail_download", err) {
-				return
-			}
+		// Only needed to know when the shared runtimes can be removed
+		if err := ReferenceRuntimes(jvmManagers); err != nil {
+			fmt.Println("Failed to reference the shared runtimes:", err)
+		}
+
+		// The previous versions of the files are only kept alive by the store now
+		if freed, err := store.Prune(); err != nil {
+			fmt.Println("Failed to prune the object store:", err)
+		} else if freed > 0 {
+			fmt.Printf("Removed %v of unused objects\n", FormatBytes(freed))
+		}
 
-			out.Close()
+		if ShowError(window, "fail_install", launcherManager.Commit()) {
+			return
+		}
 
-			if f.Executable {
-				err := os.Chmod(f.Path, os.ModePerm)
-				if ShowError(window, "fail_download", err) {
-					return
-				}
+		if err := index.Save(); err != nil {
+			fmt.Println("Failed to save the file index:", err)
+		}
+
+		for _, m := range jvmManagers {
+			err := m.SelfTest(ctx)
+			if ctx.Err() != nil {
+				return
 			}
 
-			done <- n
+			var selfTestErr *JavaSelfTestError
+			if errors.As(err, &selfTestErr) && len(selfTestErr.Property) == 0 {
+				window.SetContent(
+					container.NewVBox(
+						widget.NewLabel(Localize("java_self_test_failed", map[string]string{"Component": selfTestErr.Component})),
+						widget.NewLabel(selfTestErr.Err.Error()),
+					),
+				)
+				window.CenterOnScreen()
+
+				return
+			} else if errors.As(err, &selfTestErr) {
+				window.SetContent(
+					container.NewVBox(
+						widget.NewLabel(Localize("java_self_test_mismatch", map[string]string{
+							"Component": selfTestErr.Component,
+							"Property":  selfTestErr.Property,
+							"Expected":  selfTestErr.Expected,
+							"Actual":    selfTestErr.Actual,
+						})),
+					),
+				)
+				window.CenterOnScreen()
 
-			processedFiles += 1
-			mainProgressBar.SetValue(float64(processedFiles) / float64(len(filesToDownload)))
+				return
+			}
 		}
 
 		// Launching the launcher
 		// @TODO: Handle other than java
-		executablePath := ""
 		classpathSeparator := ":"
-		if runtime.GOOS == "darwin" {
-			executablePath = "jre.bundle/Contents/Home/bin/java"
-		} else if runtime.GOOS == "linux" {
-			executablePath = "bin/java"
-		} else if runtime.GOOS == "windows" {
-			executablePath = "bin/javaw.exe"
+		if runtime.GOOS == "windows" {
 			classpathSeparator = ";"
-		} else {
-			// I don't currently handle BSD/Solaris/whatever people try to use it on
-			panic("How did we get here?")
 		}
 
 		classpath := []string{}
@@ -309,6 +490,20 @@ func main() {
 			"isPortable": len(*basepath) > 0,
 		}
 
+		// ${javaHome.game} and ${javaPath.game} for a component whose purpose is "game"
+		// They are empty for the optional components that are not available
+		for _, c := range launcherManager.launcherManifest.Java.Components {
+			variables["javaHome."+c.Purpose] = ""
+			variables["javaPath."+c.Purpose] = ""
+		}
+
+		for _, m := range jvmManagers {
+			for _, purpose := range m.purposes {
+				variables["javaHome."+purpose] = m.GetPath()
+				variables["javaPath."+purpose] = m.GetJavaPath()
+			}
+		}
+
 		cmdStrArr := []string{
 			"-classpath",
 			strings.Join(classpath, classpathSeparator),
@@ -326,7 +521,7 @@ func main() {
 		}
 
 		cmd := exec.Command(
-			filepath.Join(jvmManager.GetPath(), executablePath),
+			jvmManager.GetJavaPath(),
 			cmdStrArr...,
 		)
 
@@ -354,6 +549,22 @@ func main() {
 	window.ShowAndRun()
 }
 
+// The files are downloaded in the staging trees, or in the shared folder,
+// the player knows them by their path in the launcher folder
+func displayPath(settings *BootstrapSettings, launcherManager *LauncherManager, jvmManagers []*JvmManager, path string) string {
+	if rel, ok := launcherManager.transaction.RelativePath(path); ok {
+		return filepath.Join("launcher", rel)
+	}
+
+	for _, m := range jvmManagers {
+		if rel, ok := m.transaction.RelativePath(path); ok {
+			return filepath.Join("runtime", m.launcherManifest.Component, m.os, rel)
+		}
+	}
+
+	return strings.TrimPrefix(path, settings.LauncherPath)
+}
+
 func ShowError(w fyne.Window, translation string, err error) bool {
 	if err != nil {
 		w.SetContent(
@@ -0,0 +1,171 @@
+/**
+ * Spectrum-Bootstrap - A bootstrap for Minecraft launchers
+ * Copyright (C) 2023-2024 - Oxodao
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ **/
+
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MIRROR_PROBE_TIMEOUT = 5 * time.Second
+	UNREACHABLE_LATENCY  = time.Duration(math.MaxInt64)
+)
+
+// Returns the urls followed by their equivalent on every mirror
+// The mirrors are base URLs, i.e. "https://mc.example.com/" and "https://mirror.example.org/mc/"
+// so "https://mc.example.com/launcher.jar" is also available at "https://mirror.example.org/mc/launcher.jar"
+func ExpandMirrors(mirrors []string, urls ...string) []string {
+	expanded := []string{}
+
+	for _, u := range urls {
+		if len(u) > 0 && !slices.Contains(expanded, u) {
+			expanded = append(expanded, u)
+		}
+	}
+
+	for _, u := range urls {
+		for _, base := range mirrors {
+			if !strings.HasPrefix(u, base) {
+				continue
+			}
+
+			for _, other := range mirrors {
+				mirrored := other + strings.TrimPrefix(u, base)
+				if !slices.Contains(expanded, mirrored) {
+					expanded = append(expanded, mirrored)
+				}
+			}
+		}
+	}
+
+	return expanded
+}
+
+// Measures how long each host takes to answer
+// so that the fastest mirrors can be tried first
+type MirrorLatencies struct {
+	bSettings *BootstrapSettings
+
+	mtx       sync.Mutex
+	latencies map[string]time.Duration
+	// Hosts currently being probed, closed once done
+	probing map[string]chan struct{}
+}
+
+func NewMirrorLatencies(bs *BootstrapSettings) *MirrorLatencies {
+	return &MirrorLatencies{
+		bSettings: bs,
+		latencies: map[string]time.Duration{},
+		probing:   map[string]chan struct{}{},
+	}
+}
+
+// Sorts the urls from the fastest host to the slowest one
+// Unreachable hosts are kept at the end in their original order
+func (m *MirrorLatencies) Sort(ctx context.Context, urls []string) []string {
+	latencies := make([]time.Duration, len(urls))
+	for i, u := range urls {
+		latencies[i] = m.latency(ctx, u)
+	}
+
+	indexes := make([]int, len(urls))
+	for i := range indexes {
+		indexes[i] = i
+	}
+
+	sort.SliceStable(indexes, func(a, b int) bool {
+		return latencies[indexes[a]] < latencies[indexes[b]]
+	})
+
+	sorted := make([]string, len(urls))
+	for i, idx := range indexes {
+		sorted[i] = urls[idx]
+	}
+
+	return sorted
+}
+
+func (m *MirrorLatencies) latency(ctx context.Context, rawUrl string) time.Duration {
+	if isLocalUrl(rawUrl) {
+		return 0
+	}
+
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return UNREACHABLE_LATENCY
+	}
+
+	m.mtx.Lock()
+	if latency, ok := m.latencies[u.Host]; ok {
+		m.mtx.Unlock()
+
+		return latency
+	}
+
+	// Another worker is already probing this host, we wait for it
+	if done, ok := m.probing[u.Host]; ok {
+		m.mtx.Unlock()
+		<-done
+
+		return m.latency(ctx, rawUrl)
+	}
+
+	done := make(chan struct{})
+	m.probing[u.Host] = done
+	m.mtx.Unlock()
+
+	latency := m.probe(ctx, rawUrl)
+
+	m.mtx.Lock()
+	m.latencies[u.Host] = latency
+	delete(m.probing, u.Host)
+	m.mtx.Unlock()
+	close(done)
+
+	return latency
+}
+
+func (m *MirrorLatencies) probe(ctx context.Context, rawUrl string) time.Duration {
+	ctx, cancel := context.WithTimeout(ctx, MIRROR_PROBE_TIMEOUT)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "HEAD", rawUrl, nil)
+	if err != nil {
+		return UNREACHABLE_LATENCY
+	}
+
+	SetUserAgent(m.bSettings, req)
+
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return UNREACHABLE_LATENCY
+	}
+	resp.Body.Close()
+
+	return time.Since(start)
+}
@@ -23,7 +23,25 @@ type BootstrapSettings struct {
 	Brand       string `json:"launcher_brand"`
 	FolderName  string `json:"launcher_foldername"`
 
+	// Tried in order when ManifestURL can't be fetched
+	ManifestMirrors     []string `json:"launcher_manifest_mirrors,omitempty"`
+	PreferFastestMirror bool     `json:"prefer_fastest_mirror,omitempty"`
+
+	// In minutes, the cached manifests are used without checking for updates during this time
+	ManifestTTL int `json:"manifest_ttl,omitempty"`
+
+	// Whether the Java runtimes are shared with the other brands that opted in
+	SharedRuntime bool `json:"shared_runtime,omitempty"`
+
+	// Overrides the keys of the Java manifest used for a platform, i.e. {"linux/arm64": ["linux-aarch64"]}
+	OsMapping map[string][]string `json:"os_mapping,omitempty"`
+
+	MaxDownloads        int `json:"max_downloads,omitempty"`
+	MaxDownloadsPerHost int `json:"max_downloads_per_host,omitempty"`
+
 	LauncherPath string `json:"-"`
+	// Given by the player with --java-home, used instead of the runtimes of the manifest when it has the required version
+	JavaHome string `json:"-"`
 }
 
 type LauncherVersion struct {
@@ -32,20 +50,64 @@ type LauncherVersion struct {
 }
 
 type ManifestFile struct {
-	Type string `json:"type"`
-	Path string `json:"path"`
-	Hash string `json:"hash"`
-	Url  string `json:"url"`
-	Size int    `json:"size"`
+	Type string   `json:"type"`
+	Path string   `json:"path"`
+	Hash string   `json:"hash"`
+	Url  string   `json:"url"`
+	Urls []string `json:"urls,omitempty"`
+	Size int      `json:"size"`
+
+	// Optional, downloaded instead of the file when possible
+	Compressed *ManifestFileCompressed `json:"compressed,omitempty"`
+
+	// Optional, patches from previous versions of the file
+	Patches []ManifestFilePatch `json:"patches,omitempty"`
+}
+
+// Turns the previous version of a file, whose sha256 is From, into the current one
+// The result is checked against the hash of the file
+type ManifestFilePatch struct {
+	From   string   `json:"from"`
+	Format string   `json:"format"`
+	Url    string   `json:"url"`
+	Urls   []string `json:"urls,omitempty"`
+}
+
+// A compressed version of a file
+// The hash of the file is checked against the decompressed output
+type ManifestFileCompressed struct {
+	Format string   `json:"format"`
+	Url    string   `json:"url"`
+	Urls   []string `json:"urls,omitempty"`
 }
 
 type LauncherJavaManifest struct {
 	ManifestURL string `json:"manifest"`
 	Component   string `json:"component"`
+	// Optional, either the exact name of a version or a semver constraint like "17" or ">=17, <21"
+	// The newest version matching it is used
+	Version string `json:"version,omitempty"`
+	// Optional, same as the one of the settings which takes precedence over it
+	OsMapping map[string][]string `json:"os_mapping,omitempty"`
+
+	// The other runtimes the launcher needs, i.e. for the game
+	Components []LauncherJavaComponent `json:"components,omitempty"`
+}
+
+type LauncherJavaComponent struct {
+	Component string `json:"component"`
+	// Tells the launcher what to use it for, its path is given to the launcher through the arguments
+	Purpose string `json:"purpose"`
+	// Optional, same as the version of the launcher runtime
+	Version string `json:"version,omitempty"`
+	// Optional components are skipped when they are not available for the os
+	Optional bool `json:"optional,omitempty"`
 }
 
 type LauncherManifest struct {
 	Version   string               `json:"version"`
+	BaseUrl   string               `json:"base_url,omitempty"`
+	Mirrors   []string             `json:"mirrors,omitempty"`
 	Files     []ManifestFile       `json:"files"`
 	MainClass string               `json:"main_class"`
 	Args      []string             `json:"args"`
@@ -61,7 +123,9 @@ type JavaManifestFileDownload struct {
 type JavaManifestFile struct {
 	Type       string `json:"type"`
 	Executable bool   `json:"executable"`
-	Downloads  struct {
+	// Only for links, relative to the directory of the link
+	Target    string `json:"target,omitempty"`
+	Downloads struct {
 		LZMA JavaManifestFileDownload `json:"lzma"`
 		Raw  JavaManifestFileDownload `json:"raw"`
 	} `json:"downloads"`
@@ -71,13 +135,16 @@ type JavaManifest struct {
 	Files map[string]JavaManifestFile `json:"files"`
 }
 
+// A progressive rollout, the version is available to Progress% of the installs
+type JavaAvailability struct {
+	Group    int `json:"group"`
+	Progress int `json:"progress"`
+}
+
 type MainJavaManifestVersion struct {
-	Availability struct {
-		Group    int `json:"group"`
-		Progress int `json:"progress"`
-	} `json:"availability"`
-	Manifest JavaManifestFileDownload `json:"manifest"`
-	Version  struct {
+	Availability *JavaAvailability        `json:"availability,omitempty"`
+	Manifest     JavaManifestFileDownload `json:"manifest"`
+	Version      struct {
 		Name     string `json:"name"`
 		Released string `json:"released"`
 	} `json:"version"`
@@ -87,10 +154,30 @@ type MainJavaManifestVersion struct {
 type MainJavaManifest map[string]map[string][]MainJavaManifestVersion
 
 type Downloadable struct {
-	Url        string
+	// Tried in order until one of them works
+	Urls       []string
 	Path       string
 	Sha1       string
 	Sha256     string
 	Size       int
 	Executable bool
+
+	// Optional, tried before Urls which are used as a fallback
+	Compressed *CompressedDownloadable
+
+	// Optional, tried before anything else when the previous version of the file
+	// is the one a patch applies to
+	Patches  []PatchDownloadable
+	Previous string
+}
+
+type PatchDownloadable struct {
+	From   string
+	Format string
+	Urls   []string
+}
+
+type CompressedDownloadable struct {
+	Format string
+	Urls   []string
 }
@@ -0,0 +1,152 @@
+/**
+ * Spectrum-Bootstrap - A bootstrap for Minecraft launchers
+ * Copyright (C) 2023-2024 - Oxodao
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ **/
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The folder, next to the ones of the launchers, containing what they share
+const SHARED_FOLDER_NAME = "spectrum-bootstrap"
+
+// Every file we know the hash of, stored once like Mojang's assets/objects
+// The trees are made of hardlinks to those objects so that the files shared
+// between the launchers of every brand are only downloaded and stored once
+type ObjectStore struct {
+	root  string
+	index *FileIndex
+}
+
+func GetObjectStore(root string, index *FileIndex) *ObjectStore {
+	return &ObjectStore{
+		root:  root,
+		index: index,
+	}
+}
+
+// objects/<algorithm>/<first two chars>/<hash>
+// A nil store is a disabled one, it never has any object
+func (s *ObjectStore) objectPath(f Downloadable) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+
+	algorithm, hash := "sha256", f.Sha256
+	if len(hash) == 0 {
+		algorithm, hash = "sha1", f.Sha1
+	}
+
+	hash = strings.ToLower(hash)
+	if len(hash) < 2 || strings.ContainsAny(hash, `/\.`) {
+		return "", false
+	}
+
+	return filepath.Join(s.root, algorithm, hash[:2], hash), true
+}
+
+// Puts the object matching the file at path
+// Returns false when the store does not have it
+func (s *ObjectStore) Materialize(f Downloadable, path string) (bool, error) {
+	objectPath, ok := s.objectPath(f)
+	if !ok || s.index.VerifyFile(objectPath, f) != nil {
+		return false, nil
+	}
+
+	// Pruned by another bootstrap in the meantime
+	if err := linkOrCopy(objectPath, path); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	// Hardlinks keep the same inode, but the copy does not
+	return true, s.index.Record(path, f, s.index.Source(objectPath))
+}
+
+// Adds a file that was verified to the store
+func (s *ObjectStore) Add(path string, f Downloadable) error {
+	objectPath, ok := s.objectPath(f)
+	if !ok || s.index.VerifyFile(objectPath, f) == nil {
+		return nil
+	}
+
+	// Another bootstrap might be adding the same object at the same time
+	tmpPath := fmt.Sprintf("%v.%x.tmp", objectPath, rand.Int63())
+	if err := linkOrCopy(path, tmpPath); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, objectPath); err != nil {
+		os.Remove(tmpPath)
+
+		return err
+	}
+
+	return s.index.Record(objectPath, f, s.index.Source(path))
+}
+
+// Removes the objects that are not linked from any install anymore, i.e. the previous versions of the files
+// The objects that were copied because the filesystem can't link them are removed too,
+// they are not worth the space they take twice
+// Returns the amount of bytes freed
+func (s *ObjectStore) Prune() (int64, error) {
+	if s == nil {
+		return 0, nil
+	}
+
+	var freed int64
+	err := filepath.WalkDir(s.root, func(path string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		// An unknown count is not a reason to remove it
+		count, err := fileLinkCount(path)
+		if err != nil || count != 1 {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return nil
+		}
+
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("Failed to remove the unused object %v: %v\n", path, err)
+
+			return nil
+		}
+		freed += fi.Size()
+
+		return nil
+	})
+
+	return freed, err
+}
@@ -0,0 +1,60 @@
+/**
+ * Spectrum-Bootstrap - A bootstrap for Minecraft launchers
+ * Copyright (C) 2023-2024 - Oxodao
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ **/
+
+package main
+
+import (
+	"runtime"
+)
+
+// The keys of the Java manifest to try, in order, for each platform
+// A platform is "{GOOS}/{GOARCH}", or "linux-musl/{GOARCH}" on musl based distributions like Alpine
+var DEFAULT_OS_MAPPING = map[string][]string{
+	"linux/amd64":      {"linux"},
+	"linux/386":        {"linux-i386"},
+	"linux/arm64":      {"linux-arm64", "linux-aarch64"},
+	"linux/arm":        {"linux-arm32", "linux-arm"},
+	"linux-musl/amd64": {"linux-musl", "linux-musl-x64", "alpine-linux"},
+	"linux-musl/arm64": {"linux-musl-arm64", "linux-musl-aarch64"},
+	"darwin/amd64":     {"mac-os"},
+	"darwin/arm64":     {"mac-os-arm64", "mac-os"}, // Rosetta
+	"windows/386":      {"windows-x86"},
+	"windows/amd64":    {"windows-x64"},
+	"windows/arm64":    {"windows-arm64", "windows-x64"}, // x64 emulation
+}
+
+func GetPlatform() string {
+	goos := runtime.GOOS
+	if goos == "linux" && isMusl() {
+		goos = "linux-musl"
+	}
+
+	return goos + "/" + runtime.GOARCH
+}
+
+// Returns the keys of the Java manifest to try for the platform
+// The first mapping having the platform is used, the default one being the last
+func GetOsCandidates(platform string, mappings ...map[string][]string) []string {
+	for _, mapping := range append(mappings, DEFAULT_OS_MAPPING) {
+		if keys, ok := mapping[platform]; ok {
+			return keys
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+/**
+ * Spectrum-Bootstrap - A bootstrap for Minecraft launchers
+ * Copyright (C) 2023-2024 - Oxodao
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ **/
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The throughput is computed on the last few seconds
+// so that the ETA follows the current speed of the connection
+const THROUGHPUT_WINDOW = 5 * time.Second
+
+type ProgressSnapshot struct {
+	TotalBytes     int64
+	DoneBytes      int64
+	BytesPerSecond float64
+	Elapsed        time.Duration
+	// 0 when it can't be estimated yet
+	ETA time.Duration
+}
+
+// Keeps track of the bytes downloaded by every worker
+// All of its methods are safe for concurrent use
+type ProgressReporter struct {
+	totalBytes atomic.Int64
+	doneBytes  atomic.Int64
+
+	start time.Time
+
+	samplesMtx sync.Mutex
+	samples    []progressSample
+}
+
+type progressSample struct {
+	at   time.Time
+	done int64
+}
+
+func NewProgressReporter(files []Downloadable) *ProgressReporter {
+	p := &ProgressReporter{start: time.Now()}
+
+	for _, f := range files {
+		p.totalBytes.Add(int64(f.Size))
+	}
+
+	return p
+}
+
+// Used for files whose size is only known once the server answered
+func (p *ProgressReporter) AddTotal(n int64) {
+	p.totalBytes.Add(n)
+}
+
+// n can be negative when downloaded bytes are thrown away
+func (p *ProgressReporter) Add(n int64) {
+	p.doneBytes.Add(n)
+}
+
+func (p *ProgressReporter) Snapshot() ProgressSnapshot {
+	now := time.Now()
+	snapshot := ProgressSnapshot{
+		TotalBytes: p.totalBytes.Load(),
+		DoneBytes:  p.doneBytes.Load(),
+		Elapsed:    now.Sub(p.start),
+	}
+
+	p.samplesMtx.Lock()
+	p.samples = append(p.samples, progressSample{at: now, done: snapshot.DoneBytes})
+	for len(p.samples) > 2 && now.Sub(p.samples[0].at) > THROUGHPUT_WINDOW {
+		p.samples = p.samples[1:]
+	}
+	oldest := p.samples[0]
+	p.samplesMtx.Unlock()
+
+	if elapsed := now.Sub(oldest.at).Seconds(); elapsed > 0 {
+		snapshot.BytesPerSecond = float64(snapshot.DoneBytes-oldest.done) / elapsed
+	}
+
+	if snapshot.BytesPerSecond > 0 && snapshot.TotalBytes > snapshot.DoneBytes {
+		remaining := float64(snapshot.TotalBytes-snapshot.DoneBytes) / snapshot.BytesPerSecond
+		snapshot.ETA = time.Duration(remaining * float64(time.Second))
+	}
+
+	return snapshot
+}
+
+// An io.Reader counting what goes through it
+type countingReader struct {
+	r      io.Reader
+	onRead func(n int64)
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	if n > 0 {
+		cr.onRead(int64(n))
+	}
+
+	return n, err
+}
+
+func FormatBytes(n int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB"}
+
+	value := float64(n)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+
+	if unit == 0 {
+		return fmt.Sprintf("%v %v", n, units[unit])
+	}
+
+	return fmt.Sprintf("%.1f %v", value, units[unit])
+}
+
+func FormatDuration(duration time.Duration) string {
+	duration = duration.Round(time.Second)
+	hours := duration / time.Hour
+	duration -= hours * time.Hour
+	minutes := duration / time.Minute
+	duration -= minutes * time.Minute
+	seconds := duration / time.Second
+
+	return fmt.Sprintf("%02d:%02d:%02d", hours, minutes, seconds)
+}
@@ -0,0 +1,81 @@
+/**
+ * Spectrum-Bootstrap - A bootstrap for Minecraft launchers
+ * Copyright (C) 2023-2024 - Oxodao
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ **/
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+const RUNTIME_REGISTRY_FILE = "runtimes.json"
+
+// Written to $basepath/runtimes.json so that the launcher can pick a runtime
+// without knowing how the bootstrap lays them out
+type RuntimeRegistry struct {
+	Runtimes []RuntimeRegistryEntry `json:"runtimes"`
+}
+
+type RuntimeRegistryEntry struct {
+	Component string   `json:"component"`
+	Purposes  []string `json:"purposes"`
+	Os        string   `json:"os"`
+	Version   string   `json:"version"`
+	Released  string   `json:"released"`
+	// The root of the runtime and its java binary
+	Path string `json:"path"`
+	Java string `json:"java"`
+	// "manifest" when installed by the bootstrap, "system" or "user" otherwise
+	Source string `json:"source"`
+
+	VerifiedAt time.Time `json:"verified_at"`
+}
+
+// Adds or replaces the entry of the runtime
+// The runtimes that were removed since are dropped from the registry
+func UpdateRuntimeRegistry(bs *BootstrapSettings, entry RuntimeRegistryEntry) error {
+	path := filepath.Join(bs.LauncherPath, RUNTIME_REGISTRY_FILE)
+
+	registry, err := LoadFromCache[RuntimeRegistry](path)
+	if err != nil {
+		return err
+	} else if registry == nil {
+		registry = &RuntimeRegistry{}
+	}
+
+	registry.Runtimes = slices.DeleteFunc(registry.Runtimes, func(e RuntimeRegistryEntry) bool {
+		return (e.Component == entry.Component && e.Os == entry.Os) || !exists(e.Path)
+	})
+	registry.Runtimes = append(registry.Runtimes, entry)
+
+	data, err := json.MarshalIndent(registry, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// The launcher might be reading it at the same time
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0666); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,207 @@
+/**
+ * Spectrum-Bootstrap - A bootstrap for Minecraft launchers
+ * Copyright (C) 2023-2024 - Oxodao
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ **/
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Java runtimes shared by the bootstraps of every brand, when they opt in
+// Each runtime is referenced by the launchers using it and removed once none of them does
+type RuntimeStore struct {
+	root string
+	// The folder of this launcher, used to reference the runtimes
+	launcherPath string
+}
+
+// The launcher folders using each runtime, by path relative to the store
+type runtimeRefs map[string][]string
+
+func GetRuntimeStore(sharedPath, launcherPath string) *RuntimeStore {
+	return &RuntimeStore{
+		root:         filepath.Join(sharedPath, "runtimes"),
+		launcherPath: launcherPath,
+	}
+}
+
+// Runtimes are only shared between brands using the same Java manifest and version,
+// each brand might pick another version of the component
+// <root>/<hash of the manifest url>/<component>-<version>/<os>
+func (s *RuntimeStore) RuntimePath(manifestUrl, component, version, os string) string {
+	sum := sha256.Sum256([]byte(manifestUrl))
+	version = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:`, r) {
+			return '_'
+		}
+
+		return r
+	}, version)
+
+	return filepath.Join(s.root, hex.EncodeToString(sum[:8]), component+"-"+version, os)
+}
+
+// Held while installing the runtime so that two bootstraps don't install it at the same time
+// and so that it is not garbage collected in the meantime
+func (s *RuntimeStore) LockRuntime(ctx context.Context, runtimePath string) (*FileLock, error) {
+	lock, ok, err := TryLock(runtimePath + ".lock")
+	if err != nil || ok {
+		return lock, err
+	}
+
+	fmt.Println("Waiting for another bootstrap to finish installing", runtimePath)
+
+	return AcquireLock(ctx, runtimePath+".lock")
+}
+
+// Makes the runtimes the ones used by this launcher, replacing the ones it used before,
+// and removes the ones no launcher uses anymore
+func (s *RuntimeStore) Reference(ctx context.Context, runtimePaths []string) error {
+	lock, err := AcquireLock(ctx, filepath.Join(s.root, "refs.lock"))
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	refs, err := s.loadRefs()
+	if err != nil {
+		return err
+	}
+
+	for runtime, users := range refs {
+		refs[runtime] = slices.DeleteFunc(users, func(user string) bool {
+			return user == s.launcherPath
+		})
+	}
+
+	for _, runtimePath := range runtimePaths {
+		key, err := s.key(runtimePath)
+		if err != nil {
+			return err
+		}
+		refs[key] = append(refs[key], s.launcherPath)
+	}
+
+	s.collect(refs)
+
+	return s.saveRefs(refs)
+}
+
+func (s *RuntimeStore) key(runtimePath string) (string, error) {
+	rel, err := filepath.Rel(s.root, runtimePath)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.ToSlash(rel), nil
+}
+
+// A refs.json that can't be read is not an empty one,
+// collecting with it would remove the runtimes of the other brands
+func (s *RuntimeStore) loadRefs() (runtimeRefs, error) {
+	path := filepath.Join(s.root, "refs.json")
+
+	refs, err := LoadFromCache[runtimeRefs](path)
+	if err != nil {
+		return nil, err
+	} else if refs == nil && exists(path) {
+		return nil, fmt.Errorf("%v is corrupted, remove it so that the unused runtimes can be removed again", path)
+	} else if refs == nil || *refs == nil {
+		return runtimeRefs{}, nil
+	}
+
+	return *refs, nil
+}
+
+func (s *RuntimeStore) saveRefs(refs runtimeRefs) error {
+	path := filepath.Join(s.root, "refs.json")
+
+	data, err := json.MarshalIndent(refs, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Writing then renaming so that a crash never leaves a half-written file
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0666); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// Removes the runtimes that are not referenced anymore
+// The ones being installed are locked and left alone
+func (s *RuntimeStore) collect(refs runtimeRefs) {
+	// The launchers that were uninstalled don't use their runtime anymore
+	for runtime, users := range refs {
+		refs[runtime] = slices.DeleteFunc(users, func(user string) bool {
+			return !exists(user)
+		})
+
+		if len(refs[runtime]) == 0 {
+			delete(refs, runtime)
+		}
+	}
+
+	matches, err := filepath.Glob(filepath.Join(s.root, "*", "*", "*"))
+	if err != nil {
+		return
+	}
+
+	runtimes := []string{}
+	for _, match := range matches {
+		// The transaction files belong to the runtime they update
+		for _, suffix := range []string{TX_STAGING_SUFFIX, TX_BACKUP_SUFFIX, TX_JOURNAL_SUFFIX, ".lock"} {
+			match = strings.TrimSuffix(match, suffix)
+		}
+
+		if !slices.Contains(runtimes, match) {
+			runtimes = append(runtimes, match)
+		}
+	}
+
+	for _, runtimePath := range runtimes {
+		key, err := s.key(runtimePath)
+		if err != nil || len(refs[key]) > 0 {
+			continue
+		}
+
+		lock, ok, err := TryLock(runtimePath + ".lock")
+		if err != nil || !ok {
+			continue
+		}
+
+		fmt.Println("Removing the unused runtime", runtimePath)
+		for _, path := range []string{runtimePath, runtimePath + TX_STAGING_SUFFIX, runtimePath + TX_BACKUP_SUFFIX, runtimePath + TX_JOURNAL_SUFFIX} {
+			if err := os.RemoveAll(path); err != nil {
+				fmt.Println("Failed to remove", path, err)
+			}
+		}
+
+		lock.Release()
+	}
+}
@@ -0,0 +1,214 @@
+/**
+ * Spectrum-Bootstrap - A bootstrap for Minecraft launchers
+ * Copyright (C) 2023-2024 - Oxodao
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ **/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Somewhere manifests and files can be fetched from
+// They all answer like an HTTP server would so that the callers don't have to care
+// Any non-2xx status is returned as an HttpStatusError and the caller has to close the body
+type Source interface {
+	Get(ctx context.Context, bs *BootstrapSettings, rawUrl string, header http.Header) (*http.Response, error)
+}
+
+// The sources by url scheme
+var sources = map[string]Source{
+	"http":  httpSource{},
+	"https": httpSource{},
+	"file":  fileSource{},
+}
+
+var windowsDrivePath = regexp.MustCompile(`^/[a-zA-Z]:/`)
+
+func GetSource(rawUrl string) (Source, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	source, ok := sources[strings.ToLower(u.Scheme)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported url scheme: %v", rawUrl)
+	}
+
+	return source, nil
+}
+
+// Turns plain filesystem paths into file:// urls, other urls are left untouched
+func NormalizeUrl(rawUrl string) string {
+	if !isLocalPath(rawUrl) {
+		return rawUrl
+	}
+
+	// Directories are used as base urls, they need to keep their trailing slash
+	isDir := strings.HasSuffix(rawUrl, "/") || strings.HasSuffix(rawUrl, string(filepath.Separator))
+
+	if abs, err := filepath.Abs(rawUrl); err == nil {
+		rawUrl = abs
+	}
+
+	u := url.URL{Scheme: "file", Path: filepath.ToSlash(rawUrl)}
+	// Windows paths start with the drive letter
+	if !strings.HasPrefix(u.Path, "/") {
+		u.Path = "/" + u.Path
+	}
+
+	if isDir && !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+
+	return u.String()
+}
+
+func isLocalPath(rawUrl string) bool {
+	if len(rawUrl) == 0 {
+		return false
+	}
+
+	// "C:\" would otherwise be parsed as an url with a "c" scheme
+	if len(filepath.VolumeName(rawUrl)) > 0 {
+		return true
+	}
+
+	u, err := url.Parse(rawUrl)
+
+	return err != nil || len(u.Scheme) == 0
+}
+
+// Reads files from the disk, i.e. from an USB stick or a network share
+type fileSource struct{}
+
+func (fileSource) Get(ctx context.Context, bs *BootstrapSettings, rawUrl string, header http.Header) (*http.Response, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(u.Host) > 0 && u.Host != "localhost" {
+		return nil, fmt.Errorf("remote file urls are not supported: %v", rawUrl)
+	}
+
+	path := u.Path
+	if windowsDrivePath.MatchString(path) {
+		path = path[1:]
+	}
+
+	f, err := os.Open(filepath.FromSlash(path))
+	if os.IsNotExist(err) {
+		return nil, &HttpStatusError{Url: rawUrl, StatusCode: http.StatusNotFound}
+	} else if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+
+		return nil, err
+	}
+
+	if fi.IsDir() {
+		f.Close()
+
+		return nil, &HttpStatusError{Url: rawUrl, StatusCode: http.StatusNotFound}
+	}
+
+	lastModified := fi.ModTime().UTC().Format(http.TimeFormat)
+	resp := &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{},
+		Body:          f,
+		ContentLength: fi.Size(),
+	}
+	resp.Header.Set("Accept-Ranges", "bytes")
+	resp.Header.Set("Last-Modified", lastModified)
+
+	if since, err := http.ParseTime(header.Get("If-Modified-Since")); err == nil && !fi.ModTime().Truncate(time.Second).After(since) {
+		f.Close()
+
+		return nil, &HttpStatusError{Url: rawUrl, StatusCode: http.StatusNotModified}
+	}
+
+	// Only the ranges sent by the downloader are supported, i.e. "bytes=1234-"
+	offset, ok := parseRangeStart(header.Get("Range"))
+	ifRange := header.Get("If-Range")
+	if !ok || (len(ifRange) > 0 && ifRange != lastModified) {
+		return resp, nil
+	}
+
+	if offset >= fi.Size() {
+		f.Close()
+
+		return nil, &HttpStatusError{Url: rawUrl, StatusCode: http.StatusRequestedRangeNotSatisfiable}
+	}
+
+	if _, err := f.Seek(offset, 0); err != nil {
+		f.Close()
+
+		return nil, err
+	}
+
+	resp.Status = "206 Partial Content"
+	resp.StatusCode = http.StatusPartialContent
+	resp.ContentLength = fi.Size() - offset
+	resp.Header.Set("Content-Range", fmt.Sprintf("bytes %v-%v/%v", offset, fi.Size()-1, fi.Size()))
+
+	return resp, nil
+}
+
+func parseRangeStart(value string) (int64, bool) {
+	value, ok := strings.CutPrefix(value, "bytes=")
+	if !ok {
+		return 0, false
+	}
+
+	value, ok = strings.CutSuffix(value, "-")
+	if !ok {
+		return 0, false
+	}
+
+	start, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || start < 0 {
+		return 0, false
+	}
+
+	return start, true
+}
+
+// Whether the url points to the disk, those are always fast to read
+func isLocalUrl(rawUrl string) bool {
+	u, err := url.Parse(NormalizeUrl(rawUrl))
+
+	return err == nil && strings.EqualFold(u.Scheme, "file")
+}
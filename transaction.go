@@ -0,0 +1,220 @@
+/**
+ * Spectrum-Bootstrap - A bootstrap for Minecraft launchers
+ * Copyright (C) 2023-2024 - Oxodao
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ **/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// An update of a tree (i.e. $basepath/launcher) is done like this:
+// - The new tree is built in "<root>.staging", the live one is not touched
+// - Once everything is downloaded and verified, the live tree is renamed to "<root>.old"
+// - The staging tree is renamed to the live one and "<root>.old" is removed
+// Each step is written to "<root>.journal.json" so that a crash can be recovered on the next start
+const (
+	TX_STAGING   = "staging"
+	TX_SWAPPING  = "swapping"
+	TX_COMMITTED = "committed"
+
+	TX_STAGING_SUFFIX = ".staging"
+	TX_BACKUP_SUFFIX  = ".old"
+	TX_JOURNAL_SUFFIX = ".journal.json"
+)
+
+type Transaction struct {
+	Root  string `json:"root"`
+	State string `json:"state"`
+
+	Staging string `json:"-"`
+	Backup  string `json:"-"`
+}
+
+func newTransaction(root string) *Transaction {
+	return &Transaction{
+		Root:    root,
+		State:   TX_STAGING,
+		Staging: root + TX_STAGING_SUFFIX,
+		Backup:  root + TX_BACKUP_SUFFIX,
+	}
+}
+
+// The path of a file of the staging or live tree, relative to the tree
+func (tx *Transaction) RelativePath(path string) (string, bool) {
+	if tx == nil {
+		return "", false
+	}
+
+	for _, tree := range []string{tx.Staging, tx.Root} {
+		rel, err := filepath.Rel(tree, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return rel, true
+		}
+	}
+
+	return "", false
+}
+
+func BeginTransaction(root string) (*Transaction, error) {
+	tx := newTransaction(root)
+
+	if err := os.MkdirAll(tx.Staging, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	return tx, tx.save()
+}
+
+// Finishes or rolls back whatever was interrupted in a previous run
+// A transaction that was still staging is returned so that it can be reused
+func RecoverTransaction(root string) (*Transaction, error) {
+	data, err := os.ReadFile(root + TX_JOURNAL_SUFFIX)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	tx := newTransaction(root)
+	if err := json.Unmarshal(data, tx); err != nil {
+		// A corrupted journal can only come from a crash while writing the staging state
+		// as the other states are written when the staging tree is complete
+		fmt.Printf("Journal for %v is corrupted, discarding the staging tree: %v\n", root, err)
+
+		return nil, tx.Rollback()
+	}
+
+	switch tx.State {
+	case TX_STAGING:
+		if !exists(tx.Staging) {
+			return nil, tx.removeJournal()
+		}
+
+		fmt.Printf("Resuming the interrupted update of %v\n", root)
+
+		return tx, nil
+	case TX_SWAPPING:
+		if !exists(tx.Staging) && !exists(tx.Root) {
+			// Crashed in the middle of the swap without the new tree, putting the old one back
+			fmt.Printf("Rolling back the interrupted update of %v\n", root)
+
+			if err := os.Rename(tx.Backup, tx.Root); err != nil {
+				return nil, err
+			}
+
+			return nil, tx.removeJournal()
+		}
+
+		// The staging tree was complete, we can finish the swap
+		fmt.Printf("Finishing the interrupted update of %v\n", root)
+
+		return nil, tx.swap()
+	case TX_COMMITTED:
+		return nil, tx.cleanup()
+	}
+
+	return nil, fmt.Errorf("unknown transaction state %v for %v", tx.State, root)
+}
+
+// Swaps the staging tree with the live one
+// Every file needs to be downloaded and verified before calling this
+func (tx *Transaction) Commit() error {
+	tx.State = TX_SWAPPING
+	if err := tx.save(); err != nil {
+		return err
+	}
+
+	return tx.swap()
+}
+
+// Drops the staging tree, leaving the live one as it was
+func (tx *Transaction) Rollback() error {
+	if err := os.RemoveAll(tx.Staging); err != nil {
+		return err
+	}
+
+	return tx.removeJournal()
+}
+
+// Each step can be replayed if we crash in the middle of it
+func (tx *Transaction) swap() error {
+	if exists(tx.Staging) {
+		if exists(tx.Root) {
+			if err := os.RemoveAll(tx.Backup); err != nil {
+				return err
+			}
+
+			if err := os.Rename(tx.Root, tx.Backup); err != nil {
+				return err
+			}
+		}
+
+		if err := os.Rename(tx.Staging, tx.Root); err != nil {
+			return err
+		}
+	}
+
+	tx.State = TX_COMMITTED
+	if err := tx.save(); err != nil {
+		return err
+	}
+
+	return tx.cleanup()
+}
+
+func (tx *Transaction) cleanup() error {
+	if err := os.RemoveAll(tx.Backup); err != nil {
+		return err
+	}
+
+	return tx.removeJournal()
+}
+
+func (tx *Transaction) save() error {
+	data, err := json.Marshal(tx)
+	if err != nil {
+		return err
+	}
+
+	// Writing then renaming so that the journal is never half-written
+	tmpPath := tx.Root + TX_JOURNAL_SUFFIX + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0666); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, tx.Root+TX_JOURNAL_SUFFIX)
+}
+
+func (tx *Transaction) removeJournal() error {
+	err := os.Remove(tx.Root + TX_JOURNAL_SUFFIX)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+
+	return err == nil
+}
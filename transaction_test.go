@@ -0,0 +1,171 @@
+/**
+ * Spectrum-Bootstrap - A bootstrap for Minecraft launchers
+ * Copyright (C) 2023-2024 - Oxodao
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ **/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Each tree holds a file named after it so that we know where it ended up
+func writeTree(t *testing.T, path, name string) {
+	t.Helper()
+
+	if err := os.MkdirAll(path, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(path, name), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func treeName(path string) string {
+	for _, name := range []string{"staging", "root", "backup"} {
+		if exists(filepath.Join(path, name)) {
+			return name
+		}
+	}
+
+	return ""
+}
+
+func TestRecoverTransaction(t *testing.T) {
+	type trees struct {
+		staging, root, backup bool
+	}
+
+	tests := []struct {
+		state string
+		trees trees
+
+		// What is left after the recovery
+		resumed bool
+		failed  bool
+		root    string
+		staging bool
+		backup  bool
+		journal bool
+	}{
+		// Still staging: resumed if the staging tree is there, nothing is moved
+		{state: TX_STAGING, trees: trees{true, true, true}, resumed: true, root: "root", staging: true, backup: true, journal: true},
+		{state: TX_STAGING, trees: trees{true, true, false}, resumed: true, root: "root", staging: true, journal: true},
+		{state: TX_STAGING, trees: trees{true, false, true}, resumed: true, staging: true, backup: true, journal: true},
+		{state: TX_STAGING, trees: trees{true, false, false}, resumed: true, staging: true, journal: true},
+		{state: TX_STAGING, trees: trees{false, true, true}, root: "root", backup: true},
+		{state: TX_STAGING, trees: trees{false, true, false}, root: "root"},
+		{state: TX_STAGING, trees: trees{false, false, true}, backup: true},
+		{state: TX_STAGING, trees: trees{false, false, false}},
+
+		// Swapping: finished when the staging tree is still there,
+		// the old tree is put back when neither the staging nor the live one exist
+		{state: TX_SWAPPING, trees: trees{true, true, true}, root: "staging"},
+		{state: TX_SWAPPING, trees: trees{true, true, false}, root: "staging"},
+		{state: TX_SWAPPING, trees: trees{true, false, true}, root: "staging"},
+		{state: TX_SWAPPING, trees: trees{true, false, false}, root: "staging"},
+		{state: TX_SWAPPING, trees: trees{false, true, true}, root: "root"},
+		{state: TX_SWAPPING, trees: trees{false, true, false}, root: "root"},
+		{state: TX_SWAPPING, trees: trees{false, false, true}, root: "backup"},
+		{state: TX_SWAPPING, trees: trees{false, false, false}, failed: true, journal: true},
+
+		// Committed: only the old tree is left to remove
+		{state: TX_COMMITTED, trees: trees{true, true, true}, root: "root", staging: true},
+		{state: TX_COMMITTED, trees: trees{true, true, false}, root: "root", staging: true},
+		{state: TX_COMMITTED, trees: trees{true, false, true}, staging: true},
+		{state: TX_COMMITTED, trees: trees{true, false, false}, staging: true},
+		{state: TX_COMMITTED, trees: trees{false, true, true}, root: "root"},
+		{state: TX_COMMITTED, trees: trees{false, true, false}, root: "root"},
+		{state: TX_COMMITTED, trees: trees{false, false, true}},
+		{state: TX_COMMITTED, trees: trees{false, false, false}},
+	}
+
+	for _, tt := range tests {
+		name := fmt.Sprintf("%v/staging=%v,root=%v,backup=%v", tt.state, tt.trees.staging, tt.trees.root, tt.trees.backup)
+
+		t.Run(name, func(t *testing.T) {
+			tx := newTransaction(filepath.Join(t.TempDir(), "launcher"))
+			tx.State = tt.state
+			if err := tx.save(); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.trees.staging {
+				writeTree(t, tx.Staging, "staging")
+			}
+			if tt.trees.root {
+				writeTree(t, tx.Root, "root")
+			}
+			if tt.trees.backup {
+				writeTree(t, tx.Backup, "backup")
+			}
+
+			resumed, err := RecoverTransaction(tx.Root)
+			if tt.failed != (err != nil) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.resumed != (resumed != nil) {
+				t.Fatalf("resumed: expected %v, got %v", tt.resumed, resumed)
+			}
+
+			if root := treeName(tx.Root); root != tt.root {
+				t.Errorf("live tree: expected %q, got %q", tt.root, root)
+			}
+			if exists(tx.Staging) != tt.staging {
+				t.Errorf("staging tree: expected %v", tt.staging)
+			}
+			if exists(tx.Backup) != tt.backup {
+				t.Errorf("old tree: expected %v", tt.backup)
+			}
+			if exists(tx.Root+TX_JOURNAL_SUFFIX) != tt.journal {
+				t.Errorf("journal: expected %v", tt.journal)
+			}
+		})
+	}
+}
+
+func TestRecoverTransactionWithoutJournal(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "launcher")
+	writeTree(t, root, "root")
+
+	tx, err := RecoverTransaction(root)
+	if tx != nil || err != nil {
+		t.Fatalf("expected nothing to recover, got %v, %v", tx, err)
+	}
+}
+
+func TestRecoverTransactionCorruptedJournal(t *testing.T) {
+	tx := newTransaction(filepath.Join(t.TempDir(), "launcher"))
+	writeTree(t, tx.Root, "root")
+	writeTree(t, tx.Staging, "staging")
+
+	if err := os.WriteFile(tx.Root+TX_JOURNAL_SUFFIX, []byte(`{"state": "swa`), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	resumed, err := RecoverTransaction(tx.Root)
+	if resumed != nil || err != nil {
+		t.Fatalf("expected a rollback, got %v, %v", resumed, err)
+	}
+
+	if treeName(tx.Root) != "root" || exists(tx.Staging) || exists(tx.Root+TX_JOURNAL_SUFFIX) {
+		t.Fatal("the staging tree should be dropped and the live one left alone")
+	}
+}
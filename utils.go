@@ -19,15 +19,20 @@
 package main
 
 import (
+	"context"
 	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"time"
 )
 
 const NOT_DOWNLOADED = "NOT_DOWNLOADED"
@@ -39,68 +44,187 @@ func SetUserAgent(bs *BootstrapSettings, req *http.Request) {
 	)
 }
 
-func GetOrCached[T interface{}](bs *BootstrapSettings, cachePath, url string) (*T, error) {
+// What we know about a cached manifest, stored next to it
+type CacheMeta struct {
+	// Where the manifest was fetched from, relative urls inside it are resolved against it
+	Url string `json:"url"`
+
+	// Validators sent by the server, so that we only download the manifest when it changed
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+
+	// The last time we made sure the manifest was up to date
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// The urls are mirrors of the same file, tried in order
+// Also returns the url the manifest comes from
+func GetOrCached[T interface{}](ctx context.Context, bs *BootstrapSettings, cachePath string, urls []string) (*T, string, error) {
 	cached, cachedErr := LoadFromCache[T](cachePath)
 	// There is no error for file not found or file corrupted
 	// So if we have an error here, there is a deeper issue and we need to raise
 	if cachedErr != nil {
-		return nil, cachedErr
+		return nil, "", cachedErr
+	}
+
+	meta, _ := LoadFromCache[CacheMeta](cachePath + ".meta")
+	if meta == nil {
+		meta = &CacheMeta{}
+	}
+
+	// Cached by an older bootstrap, it could only come from the main url
+	if len(meta.Url) == 0 && len(urls) > 0 {
+		meta.Url = urls[0]
+	}
+
+	// The manifest should now come from somewhere else, i.e. another java version,
+	// the cached one is not a fallback anymore
+	if !slices.Contains(urls, meta.Url) {
+		cached = nil
+	}
+
+	// Checked recently enough, no need to bother the server
+	ttl := time.Duration(bs.ManifestTTL) * time.Minute
+	if cached != nil && ttl > 0 && time.Since(meta.CheckedAt) < ttl {
+		return cached, meta.Url, nil
+	}
+
+	live, liveErr := (*T)(nil), fmt.Errorf("%w: %v", ErrNoUrl, cachePath)
+	liveUrl := ""
+	var liveHeader http.Header
+	for _, u := range urls {
+		// The validators only make sense for the url they come from
+		header := http.Header{}
+		if cached != nil && u == meta.Url {
+			if len(meta.ETag) > 0 {
+				header.Set("If-None-Match", meta.ETag)
+			}
+
+			if len(meta.LastModified) > 0 {
+				header.Set("If-Modified-Since", meta.LastModified)
+			}
+		}
+
+		live, liveHeader, liveErr = DoGetRequest[T](ctx, bs, u, header)
+
+		var statusErr *HttpStatusError
+		if errors.As(liveErr, &statusErr) && statusErr.StatusCode == http.StatusNotModified {
+			meta.CheckedAt = time.Now()
+
+			return cached, meta.Url, SaveToCache(cachePath+".meta", meta)
+		}
+
+		if liveErr == nil || ctx.Err() != nil {
+			liveUrl = u
+			break
+		}
+
+		fmt.Printf("Failed to fetch %v: %v\n", u, liveErr)
 	}
 
-	live, liveErr := DoGetRequest[T](bs, url)
 	// If we can't get it but the cache is loaded, no issue
 	// If we can't get it and no cache: CRASH
-	if liveErr != nil && cached != nil {
-		return cached, nil
+	// Unless the user cancelled, then we just stop there
+	if ctx.Err() != nil {
+		return nil, "", ctx.Err()
+	} else if liveErr != nil && cached != nil {
+		return cached, meta.Url, nil
 	} else if liveErr != nil {
-		return nil, liveErr
+		return nil, "", liveErr
 	}
 
 	// We got it, lets cache it while we're at it!
+	if err := SaveToCache(cachePath, live); err != nil {
+		return nil, "", err
+	}
+
+	return live, liveUrl, SaveToCache(cachePath+".meta", CacheMeta{
+		Url:          liveUrl,
+		ETag:         liveHeader.Get("ETag"),
+		LastModified: liveHeader.Get("Last-Modified"),
+		CheckedAt:    time.Now(),
+	})
+}
+
+func SaveToCache(cachePath string, data any) error {
 	err := os.MkdirAll(filepath.Dir(cachePath), os.ModePerm)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	f, err := os.Create(cachePath)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer f.Close()
 
-	data, _ := json.MarshalIndent(live, "", "  ")
-	_, err = f.Write(data)
+	out, _ := json.MarshalIndent(data, "", "  ")
+	_, err = f.Write(out)
 
-	return live, err
+	return err
 }
 
-func DoGetRequest[T interface{}](bs *BootstrapSettings, url string) (*T, error) {
-	client := &http.Client{}
+// Resolves a possibly relative url against the url of the manifest containing it
+// The manifest can come from a plain filesystem path too
+func ResolveUrl(base, ref string) string {
+	if len(ref) == 0 || len(base) == 0 {
+		return ref
+	}
 
-	req, err := http.NewRequest(
-		"GET",
-		url,
-		nil,
-	)
+	base = NormalizeUrl(base)
+	if len(filepath.VolumeName(ref)) > 0 {
+		return NormalizeUrl(ref)
+	}
 
+	refUrl, err := url.Parse(ref)
+	if err != nil || refUrl.IsAbs() {
+		return ref
+	}
+
+	baseUrl, err := url.Parse(base)
 	if err != nil {
-		return nil, err
+		return ref
 	}
 
-	SetUserAgent(bs, req)
+	return baseUrl.ResolveReference(refUrl).String()
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+// Also returns the headers of the response, i.e. to get its validators
+func DoGetRequest[T interface{}](ctx context.Context, bs *BootstrapSettings, url string, header http.Header) (*T, http.Header, error) {
+	var manifest *T
+	var respHeader http.Header
+
+	// Setting it ourselves disables the transparent gzip of the http client
+	// so it's up to us to decompress the body
+	header = header.Clone()
+	if header == nil {
+		header = http.Header{}
 	}
+	header.Set("Accept-Encoding", ACCEPT_ENCODING)
 
-	manifest := new(T)
-	err = json.NewDecoder(resp.Body).Decode(manifest)
+	err := WithRetries(ctx, func(attempt int) error {
+		resp, err := DoGet(ctx, bs, url, header)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		body, err := NewDecompressor(resp.Body, resp.Header.Get("Content-Encoding"))
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+
+		manifest = new(T)
+		respHeader = resp.Header
+
+		return json.NewDecoder(body).Decode(manifest)
+	})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	return manifest, nil
+	return manifest, respHeader, nil
 }
 
 func LoadFromCache[T interface{}](filepath string) (*T, error) {